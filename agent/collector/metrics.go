@@ -2,95 +2,130 @@ package collector
 
 import (
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/AzertoxHDW/sentinel/agent/config"
+	"github.com/AzertoxHDW/sentinel/agent/telemetry"
+	"github.com/AzertoxHDW/sentinel/internal/schema"
+
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
-	"github.com/shirou/gopsutil/v3/load"
 )
 
-// SystemMetrics holds all collected system information
-type SystemMetrics struct {
-	Timestamp   time.Time       `json:"timestamp"`
-	Hostname    string          `json:"hostname"`
-	Uptime      uint64          `json:"uptime"`
-	CPU         CPUMetrics      `json:"cpu"`
-	Memory      MemoryMetrics   `json:"memory"`
-	Disk        []DiskMetrics   `json:"disk"`
-	Network     []NetworkMetrics `json:"network"`
-}
-
-type CPUMetrics struct {
-	UsagePercent float64 `json:"usage_percent"`
-	CoreCount    int     `json:"core_count"`
-	LoadAvg      []float64 `json:"load_avg,omitempty"` // Linux/Unix only
-	Model        string    `json:"model"`
-}
-
-type MemoryMetrics struct {
-	Total       uint64  `json:"total"`
-	Available   uint64  `json:"available"`
-	Used        uint64  `json:"used"`
-	UsedPercent float64 `json:"used_percent"`
-}
-
-type DiskMetrics struct {
-	Device      string  `json:"device"`
-	MountPoint  string  `json:"mount_point"`
-	FSType      string  `json:"fs_type"`
-	Total       uint64  `json:"total"`
-	Used        uint64  `json:"used"`
-	Free        uint64  `json:"free"`
-	UsedPercent float64 `json:"used_percent"`
-}
-
-type NetworkMetrics struct {
-	Interface   string `json:"interface"`
-	BytesSent   uint64 `json:"bytes_sent"`
-	BytesRecv   uint64 `json:"bytes_recv"`
-	PacketsSent uint64 `json:"packets_sent"`
-	PacketsRecv uint64 `json:"packets_recv"`
-}
+// The payload types are shared with the dashboard
+type (
+	SystemMetrics  = schema.SystemMetrics
+	CPUMetrics     = schema.CPUMetrics
+	MemoryMetrics  = schema.MemoryMetrics
+	DiskMetrics    = schema.DiskMetrics
+	NetworkMetrics = schema.NetworkMetrics
+)
 
 // Collector handles metrics collection
 type Collector struct {
-	hostname string
+	agentID   string
+	hostname  string
+	config    config.CollectorConfig
+	telemetry *telemetry.Telemetry
+	mu        sync.RWMutex
 }
 
 // NewCollector creates a new metrics collector
-func NewCollector() (*Collector, error) {
+func NewCollector(agentID string, cfg config.CollectorConfig, tel *telemetry.Telemetry) (*Collector, error) {
 	hostname, err := host.Info()
 	if err != nil {
 		return nil, err
 	}
-	
+
 	return &Collector{
-		hostname: hostname.Hostname,
+		agentID:   agentID,
+		hostname:  hostname.Hostname,
+		config:    cfg,
+		telemetry: tel,
 	}, nil
 }
 
+// AgentID returns the persistent agent identifier
+func (c *Collector) AgentID() string {
+	return c.agentID
+}
+
+// Hostname returns the hostname reported in metrics
+func (c *Collector) Hostname() string {
+	return c.hostname
+}
+
+// SetConfig replaces the collector configuration; used on config reload
+func (c *Collector) SetConfig(cfg config.CollectorConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = cfg
+}
+
 // Collect gathers all system metrics
 func (c *Collector) Collect() (*SystemMetrics, error) {
+	c.mu.RLock()
+	cfg := c.config
+	c.mu.RUnlock()
+
 	metrics := &SystemMetrics{
-		Timestamp: time.Now(),
-		Hostname:  c.hostname,
+		SchemaVersion: schema.Version,
+		AgentID:       c.agentID,
+		Timestamp:     time.Now(),
+		Hostname:      c.hostname,
 	}
 
-	// Host info (uptime)
+	c.observe("host", func() error { return c.collectHost(metrics) })
+	if cfg.CPU {
+		c.observe("cpu", func() error { return c.collectCPU(metrics, cfg) })
+	}
+	if cfg.Memory {
+		c.observe("memory", func() error { return c.collectMemory(metrics) })
+	}
+	if cfg.Disk {
+		c.observe("disk", func() error { return c.collectDisk(metrics, cfg) })
+	}
+	if cfg.Network {
+		c.observe("network", func() error { return c.collectNetwork(metrics, cfg) })
+	}
+
+	return metrics, nil
+}
+
+// observe runs one collector and records its duration and outcome
+func (c *Collector) observe(name string, collect func() error) {
+	start := time.Now()
+	err := collect()
+	if c.telemetry != nil {
+		c.telemetry.ObserveCollection(name, time.Since(start), err)
+	}
+}
+
+func (c *Collector) collectHost(metrics *SystemMetrics) error {
 	hostInfo, err := host.Info()
-	if err == nil {
-		metrics.Uptime = hostInfo.Uptime
+	if err != nil {
+		return err
 	}
+	metrics.Uptime = hostInfo.Uptime
+	return nil
+}
 
-	// CPU metrics
-	cpuPercent, err := cpu.Percent(time.Second, false)
-	if err == nil && len(cpuPercent) > 0 {
+func (c *Collector) collectCPU(metrics *SystemMetrics, cfg config.CollectorConfig) error {
+	metrics.CPU.CoreCount = runtime.NumCPU()
+
+	cpuPercent, err := cpu.Percent(cfg.CPUSampleInterval, false)
+	if err != nil {
+		return err
+	}
+	if len(cpuPercent) > 0 {
 		metrics.CPU.UsagePercent = cpuPercent[0]
 	}
-	metrics.CPU.CoreCount = runtime.NumCPU()
 
 	// Get CPU model
 	cpuInfo, err := cpu.Info()
@@ -106,23 +141,33 @@ func (c *Collector) Collect() (*SystemMetrics, error) {
 		}
 	}
 
-	// Memory metrics
+	return nil
+}
+
+func (c *Collector) collectMemory(metrics *SystemMetrics) error {
 	memInfo, err := mem.VirtualMemory()
-	if err == nil {
-		metrics.Memory = MemoryMetrics{
-			Total:       memInfo.Total,
-			Available:   memInfo.Available,
-			Used:        memInfo.Used,
-			UsedPercent: memInfo.UsedPercent,
-		}
+	if err != nil {
+		return err
+	}
+
+	metrics.Memory = MemoryMetrics{
+		Total:       memInfo.Total,
+		Available:   memInfo.Available,
+		Used:        memInfo.Used,
+		UsedPercent: memInfo.UsedPercent,
+	}
+	return nil
+}
+
+func (c *Collector) collectDisk(metrics *SystemMetrics, cfg config.CollectorConfig) error {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return err
 	}
 
-	// Disk metrics
-partitions, err := disk.Partitions(false)
-if err == nil {
 	for _, partition := range partitions {
-		// Only collect root partition
-		if partition.Mountpoint != "/" {
+		// Only collect configured mount points
+		if !contains(cfg.MountPoints, partition.Mountpoint) {
 			continue
 		}
 
@@ -141,36 +186,20 @@ if err == nil {
 			UsedPercent: usage.UsedPercent,
 		})
 	}
-}
-
-	// Network metrics
-netIO, err := net.IOCounters(true)
-if err == nil {
-	for _, io := range netIO {
-		// Skip loopback
-		if io.Name == "lo" || io.Name == "lo0" {
-			continue
-		}
 
-		// Skip common virtual interfaces
-		// Docker, VirtualBox, VMware, Wireguard, Tailscale, etc.
-		skipPrefixes := []string{
-			"docker", "veth", "br-", "virbr", "fw", "vmbr",  // Docker/libvirt
-			"vbox", "vmnet",                     			 // VirtualBox/VMware
-			"wg", "tun", "tap",                  			 // VPN/Wireguard
-			"tailscale",                         			 // Tailscale
-			"utun",                              			 // macOS VPN
-		}
+	return nil
+}
 
-		skip := false
-		for _, prefix := range skipPrefixes {
-			if len(io.Name) >= len(prefix) && io.Name[:len(prefix)] == prefix {
-				skip = true
-				break
-			}
-		}
+func (c *Collector) collectNetwork(metrics *SystemMetrics, cfg config.CollectorConfig) error {
+	netIO, err := net.IOCounters(true)
+	if err != nil {
+		return err
+	}
 
-		if skip {
+	for _, io := range netIO {
+		// Skip loopback and configured virtual interfaces
+		// (Docker, VirtualBox, VMware, Wireguard, Tailscale, etc. by default)
+		if hasAnyPrefix(io.Name, cfg.ExcludeInterfaces) {
 			continue
 		}
 
@@ -182,7 +211,24 @@ if err == nil {
 			PacketsRecv: io.PacketsRecv,
 		})
 	}
+
+	return nil
 }
 
-	return metrics, nil
-}
\ No newline at end of file
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/AzertoxHDW/sentinel/internal/netiface"
+)
+
+// Config holds the complete agent configuration
+type Config struct {
+	// Listen is a TCP address ("host:port", "[::]:port") or "unix:/path/to.sock"
+	Listen   string        `yaml:"listen"`
+	Timeouts TimeoutConfig `yaml:"timeouts"`
+	// IDFile stores the agent's persistent UUID (defaults to a per-OS location)
+	IDFile     string          `yaml:"id_file"`
+	Auth       AuthConfig      `yaml:"auth"`
+	TLS        TLSConfig       `yaml:"tls"`
+	Collectors CollectorConfig `yaml:"collectors"`
+	MDNS       MDNSConfig      `yaml:"mdns"`
+}
+
+type TimeoutConfig struct {
+	Read     time.Duration `yaml:"read"`
+	Write    time.Duration `yaml:"write"`
+	Idle     time.Duration `yaml:"idle"`
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+type AuthConfig struct {
+	// Token is required as "Authorization: Bearer <token>" on metric endpoints.
+	// Empty disables authentication.
+	Token string `yaml:"token"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type CollectorConfig struct {
+	CPU     bool `yaml:"cpu"`
+	Memory  bool `yaml:"memory"`
+	Disk    bool `yaml:"disk"`
+	Network bool `yaml:"network"`
+
+	// CPUSampleInterval is how long CPU usage is sampled for on each collection
+	CPUSampleInterval time.Duration `yaml:"cpu_sample_interval"`
+
+	// MountPoints lists the mount points reported by the disk collector
+	MountPoints []string `yaml:"mount_points"`
+
+	// ExcludeInterfaces lists interface name prefixes skipped by the network collector
+	ExcludeInterfaces []string `yaml:"exclude_interfaces"`
+}
+
+type MDNSConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Instance overrides the advertised instance name (defaults to the hostname)
+	Instance string `yaml:"instance"`
+
+	// Tags are free-form labels advertised in the TXT records
+	Tags []string `yaml:"tags"`
+
+	// Interfaces restricts advertising to these interfaces (globs, empty = all)
+	Interfaces []string `yaml:"interfaces"`
+
+	// ExcludeInterfaces are never advertised on, e.g. docker bridges and VPNs
+	ExcludeInterfaces []string `yaml:"exclude_interfaces"`
+}
+
+// Default returns the configuration used when no file is given
+func Default() *Config {
+	return &Config{
+		Listen: ":9100",
+		Timeouts: TimeoutConfig{
+			Read:     10 * time.Second,
+			Write:    30 * time.Second,
+			Idle:     120 * time.Second,
+			Shutdown: 15 * time.Second,
+		},
+		Collectors: CollectorConfig{
+			CPU:               true,
+			Memory:            true,
+			Disk:              true,
+			Network:           true,
+			CPUSampleInterval: time.Second,
+			MountPoints:       []string{"/"},
+			ExcludeInterfaces: []string{
+				"lo",
+				"docker", "veth", "br-", "virbr", "fw", "vmbr", // Docker/libvirt
+				"vbox", "vmnet", // VirtualBox/VMware
+				"wg", "tun", "tap", // VPN/Wireguard
+				"tailscale", // Tailscale
+				"utun",      // macOS VPN
+			},
+		},
+		MDNS: MDNSConfig{
+			Enabled: true,
+		},
+	}
+}
+
+// Load reads a YAML config file on top of the defaults.
+// An empty path returns the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// ApplyEnv overrides values from SENTINEL_* environment variables
+func (c *Config) ApplyEnv() error {
+	if v, ok := os.LookupEnv("SENTINEL_LISTEN"); ok && v != "" {
+		c.Listen = v
+	}
+	if v, ok := os.LookupEnv("SENTINEL_AUTH_TOKEN"); ok {
+		c.Auth.Token = v
+	}
+	if v, ok := os.LookupEnv("SENTINEL_TLS_CERT"); ok {
+		c.TLS.CertFile = v
+	}
+	if v, ok := os.LookupEnv("SENTINEL_TLS_KEY"); ok {
+		c.TLS.KeyFile = v
+	}
+	if v, ok := os.LookupEnv("SENTINEL_MDNS"); ok && v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SENTINEL_MDNS: expected a boolean, got %q", v)
+		}
+		c.MDNS.Enabled = enabled
+	}
+	if v, ok := os.LookupEnv("SENTINEL_TAGS"); ok {
+		c.MDNS.Tags = splitList(v)
+	}
+	if v, ok := os.LookupEnv("SENTINEL_MDNS_INTERFACES"); ok {
+		c.MDNS.Interfaces = splitList(v)
+	}
+	if v, ok := os.LookupEnv("SENTINEL_MDNS_EXCLUDE_INTERFACES"); ok {
+		c.MDNS.ExcludeInterfaces = splitList(v)
+	}
+	return nil
+}
+
+// Validate checks the configuration for errors
+func (c *Config) Validate() error {
+	var errs []string
+
+	if path, ok := c.UnixSocket(); ok {
+		if path == "" {
+			errs = append(errs, "listen: unix socket path is empty")
+		}
+	} else if _, err := c.Port(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if c.Timeouts.Read <= 0 || c.Timeouts.Write <= 0 || c.Timeouts.Idle <= 0 || c.Timeouts.Shutdown <= 0 {
+		errs = append(errs, "timeouts: read, write, idle and shutdown must be positive")
+	}
+	if c.Collectors.CPU && c.Timeouts.Write <= c.Collectors.CPUSampleInterval {
+		errs = append(errs, "timeouts.write must be longer than collectors.cpu_sample_interval")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, "tls: cert_file and key_file must be set together")
+	}
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Sprintf("tls: %v", err))
+		}
+	}
+
+	if c.Collectors.CPU && c.Collectors.CPUSampleInterval <= 0 {
+		errs = append(errs, "collectors.cpu_sample_interval must be positive")
+	}
+	if c.Collectors.CPUSampleInterval > 10*time.Second {
+		errs = append(errs, "collectors.cpu_sample_interval must not exceed 10s")
+	}
+
+	for _, tag := range c.MDNS.Tags {
+		if tag == "" || strings.ContainsAny(tag, ",=") {
+			errs = append(errs, fmt.Sprintf("mdns.tags: invalid tag %q (must be non-empty and contain no ',' or '=')", tag))
+		}
+	}
+
+	for _, patterns := range [][]string{c.MDNS.Interfaces, c.MDNS.ExcludeInterfaces} {
+		if err := netiface.ValidatePatterns(patterns); err != nil {
+			errs = append(errs, "mdns: "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
+}
+
+// UnixSocket returns the socket path if the agent listens on a unix socket
+func (c *Config) UnixSocket() (string, bool) {
+	if strings.HasPrefix(c.Listen, "unix:") {
+		return strings.TrimPrefix(c.Listen, "unix:"), true
+	}
+	return "", false
+}
+
+// Port returns the TCP port from the listen address, or 0 for a unix socket
+func (c *Config) Port() (int, error) {
+	if _, ok := c.UnixSocket(); ok {
+		return 0, nil
+	}
+	_, portStr, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return 0, fmt.Errorf("listen: invalid address %q: %v", c.Listen, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("listen: invalid port %q", portStr)
+	}
+	return port, nil
+}
+
+// TLSEnabled reports whether the agent serves HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != "" && c.TLS.KeyFile != ""
+}
+
+// RequiresRestart reports whether switching from c to next changes settings
+// that cannot be applied to a running process
+func (c *Config) RequiresRestart(next *Config) bool {
+	return c.Listen != next.Listen || c.TLS != next.TLS || c.Timeouts != next.Timeouts || c.IDFile != next.IDFile
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -5,8 +5,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/AzertoxHDW/sentinel/agent/config"
+	"github.com/AzertoxHDW/sentinel/agent/version"
+	"github.com/AzertoxHDW/sentinel/internal/netiface"
 	"github.com/grandcat/zeroconf"
 )
 
@@ -15,46 +23,154 @@ const (
 	Domain      = "local."
 )
 
+// TXT record format version advertised as "txtv"
+const TXTVersion = "1"
+
 type Broadcaster struct {
-	server *zeroconf.Server
-	port   int
+	server  *zeroconf.Server
+	agentID string
+	current registration
+	mu      sync.Mutex
+}
+
+// registration is what the broadcaster advertises for a given config
+type registration struct {
+	enabled  bool
+	instance string
+	port     int
+	txt      []string
+	allow    []string
+	deny     []string
 }
 
-func NewBroadcaster(port int) *Broadcaster {
+func NewBroadcaster(agentID string) *Broadcaster {
 	return &Broadcaster{
-		port: port,
+		agentID: agentID,
+	}
+}
+
+func (b *Broadcaster) Start(cfg *config.Config) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.register(b.registrationFor(cfg))
+}
+
+// Reload updates the advertised service. TXT-only changes are pushed to the
+// running responder; the service is only re-registered when the instance
+// name, port or enabled state changed.
+func (b *Broadcaster) Reload(cfg *config.Config) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	next := b.registrationFor(cfg)
+	if next.enabled == b.current.enabled && next.instance == b.current.instance && next.port == b.current.port &&
+		reflect.DeepEqual(next.allow, b.current.allow) && reflect.DeepEqual(next.deny, b.current.deny) {
+		if !reflect.DeepEqual(next.txt, b.current.txt) && b.server != nil {
+			b.server.SetText(next.txt)
+			log.Println("mDNS TXT records updated")
+		}
+		b.current = next
+		return nil
+	}
+
+	b.shutdown()
+	return b.register(next)
+}
+
+func (b *Broadcaster) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.shutdown()
+}
+
+func (b *Broadcaster) registrationFor(cfg *config.Config) registration {
+	port, _ := cfg.Port()
+
+	instance := cfg.MDNS.Instance
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "sentinel-agent"
+		}
+		instance = hostname
+	}
+
+	txt := []string{
+		"txtv=" + TXTVersion,
+		"id=" + b.agentID,
+		"version=" + version.Version,
+		"os=" + runtime.GOOS,
+		"arch=" + runtime.GOARCH,
+		"tls=" + strconv.FormatBool(cfg.TLSEnabled()),
+		"auth=" + strconv.FormatBool(cfg.Auth.Token != ""),
+	}
+	if len(cfg.MDNS.Tags) > 0 {
+		txt = append(txt, "tags="+strings.Join(cfg.MDNS.Tags, ","))
+	}
+
+	return registration{
+		enabled:  cfg.MDNS.Enabled,
+		instance: instance,
+		port:     port,
+		txt:      txt,
+		allow:    cfg.MDNS.Interfaces,
+		deny:     cfg.MDNS.ExcludeInterfaces,
 	}
 }
 
-func (b *Broadcaster) Start() error {
-	hostname, err := os.Hostname()
+// register advertises reg. On failure nothing is advertised and the
+// current registration is cleared, so the next Reload tries again even
+// with the same config.
+func (b *Broadcaster) register(reg registration) error {
+	b.current = registration{}
+
+	if !reg.enabled {
+		b.current = reg
+		log.Println("mDNS broadcasting disabled")
+		return nil
+	}
+	if reg.port == 0 {
+		b.current = reg
+		log.Println("mDNS broadcasting skipped: agent listens on a unix socket")
+		return nil
+	}
+
+	// Advertised addresses come from the selected interfaces only
+	ifaces, err := netiface.Select(reg.allow, reg.deny)
 	if err != nil {
-		hostname = "sentinel-agent"
+		return fmt.Errorf("failed to select mDNS interfaces: %w", err)
 	}
 
 	// Register service
 	server, err := zeroconf.Register(
-		hostname,           // Instance name
-		ServiceType,        // Service type
-		Domain,            // Domain
-		b.port,            // Port
-		[]string{"txtv=0", "version=1.0"}, // TXT records
-		nil,               // Network interface (nil = all)
+		reg.instance, // Instance name
+		ServiceType,  // Service type
+		Domain,       // Domain
+		reg.port,     // Port
+		reg.txt,      // TXT records
+		ifaces,       // Network interfaces (nil = all)
 	)
-	
+
 	if err != nil {
 		return fmt.Errorf("failed to register mDNS service: %w", err)
 	}
 
 	b.server = server
-	log.Printf("mDNS service registered: %s%s on port %d", hostname, ServiceType, b.port)
-	
+	b.current = reg
+	log.Printf("mDNS service registered: %s%s on port %d", reg.instance, ServiceType, reg.port)
+	if ifaces != nil {
+		log.Printf("mDNS interfaces: %v", netiface.Names(ifaces))
+	}
+
 	return nil
 }
 
-func (b *Broadcaster) Stop() {
+func (b *Broadcaster) shutdown() {
 	if b.server != nil {
 		b.server.Shutdown()
+		b.server = nil
 		log.Println("mDNS service stopped")
 	}
 }
@@ -81,13 +197,16 @@ func (s *Scanner) Scan(timeout time.Duration) ([]*AgentInfo, error) {
 				Hostname: entry.HostName,
 				Instance: entry.Instance,
 				Port:     entry.Port,
-				IPs:      make([]string, len(entry.AddrIPv4)),
+				IPs:      make([]string, 0, len(entry.AddrIPv4)+len(entry.AddrIPv6)),
 			}
-			
-			for i, ip := range entry.AddrIPv4 {
-				agent.IPs[i] = ip.String()
+
+			for _, ip := range entry.AddrIPv4 {
+				agent.IPs = append(agent.IPs, ip.String())
 			}
-			
+			for _, ip := range entry.AddrIPv6 {
+				agent.IPs = append(agent.IPs, ip.String())
+			}
+
 			agents = append(agents, agent)
 			log.Printf("Discovered agent: %s at %v:%d", agent.Instance, agent.IPs, agent.Port)
 		}
@@ -102,7 +221,7 @@ func (s *Scanner) Scan(timeout time.Duration) ([]*AgentInfo, error) {
 	}
 
 	<-ctx.Done()
-	
+
 	return agents, nil
 }
 
@@ -111,4 +230,4 @@ type AgentInfo struct {
 	Instance string
 	Port     int
 	IPs      []string
-}
\ No newline at end of file
+}
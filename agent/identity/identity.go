@@ -0,0 +1,53 @@
+package identity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// DefaultPath returns where the agent ID is persisted when not configured
+func DefaultPath() string {
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, "Sentinel", "agent-id")
+		}
+	} else if os.Geteuid() == 0 {
+		return "/var/lib/sentinel/agent-id"
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "sentinel", "agent-id")
+	}
+	return "sentinel-agent-id"
+}
+
+// LoadOrCreate returns the agent ID stored at path, generating and saving a
+// new UUID on first start. The ID survives hostname and address changes.
+func LoadOrCreate(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id, err := uuid.Parse(strings.TrimSpace(string(data)))
+		if err != nil {
+			return "", fmt.Errorf("invalid agent ID in %s: %w", path, err)
+		}
+		return id.String(), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read agent ID: %w", err)
+	}
+
+	id := uuid.New().String()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create agent ID directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to save agent ID: %w", err)
+	}
+
+	return id, nil
+}
@@ -1,57 +1,154 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/AzertoxHDW/sentinel/agent/config"
 	"github.com/AzertoxHDW/sentinel/agent/discovery"
+	"github.com/AzertoxHDW/sentinel/agent/identity"
 	"github.com/AzertoxHDW/sentinel/agent/server"
-	"strconv"
 )
 
 func main() {
-	port := flag.String("port", "9100", "Port to listen on")
+	configFile := flag.String("config", os.Getenv("SENTINEL_CONFIG"), "Path to YAML config file (env: SENTINEL_CONFIG)")
+	port := flag.String("port", "", "Port to listen on (shorthand for -listen=:PORT)")
+	listen := flag.String("listen", "", "Address to listen on (default \":9100\")")
 	flag.Parse()
 
 	log.Println("Starting Sentinel Agent...")
 	log.Printf("Hostname: %s", getHostname())
 
-	// Convert port string to int for mDNS
-	portInt, err := strconv.Atoi(*port)
+	// Flags override env, which overrides the config file
+	overrides := func(cfg *config.Config) {
+		if *port != "" {
+			cfg.Listen = ":" + *port
+		}
+		if *listen != "" {
+			cfg.Listen = *listen
+		}
+	}
+
+	cfg, err := loadConfig(*configFile, overrides)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *configFile != "" {
+		log.Printf("Loaded configuration from %s", *configFile)
+	}
+
+	shutdownTimeout := cfg.Timeouts.Shutdown
+
+	// Load or generate the persistent agent ID
+	idFile := cfg.IDFile
+	if idFile == "" {
+		idFile = identity.DefaultPath()
+	}
+	agentID, err := identity.LoadOrCreate(idFile)
 	if err != nil {
-		log.Fatalf("Invalid port: %v", err)
+		log.Fatalf("Failed to load agent ID: %v", err)
 	}
+	log.Printf("Agent ID: %s", agentID)
 
 	// Start mDNS broadcaster
-	broadcaster := discovery.NewBroadcaster(portInt)
-	if err := broadcaster.Start(); err != nil {
+	broadcaster := discovery.NewBroadcaster(agentID)
+	if err := broadcaster.Start(cfg); err != nil {
 		log.Fatalf("Failed to start mDNS broadcaster: %v", err)
 	}
-	defer broadcaster.Stop()
 
-	// Create and start HTTP server
-	srv, err := server.NewServer(*port)
+	// Create HTTP server
+	srv, err := server.NewServer(cfg, agentID)
 	if err != nil {
+		broadcaster.Stop()
 		log.Fatalf("Failed to create server: %v", err)
 	}
 
-	// Handle graceful shutdown
+	// Reload configuration on SIGHUP
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
-		log.Println("Shutting down agent...")
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		for range hupChan {
+			next, err := loadConfig(*configFile, overrides)
+			if err != nil {
+				log.Printf("Config reload failed, keeping current configuration: %v", err)
+				continue
+			}
+
+			if cfg.RequiresRestart(next) {
+				log.Println("Listen address, TLS and timeout changes require a restart; keeping current values")
+				next.Listen = cfg.Listen
+				next.TLS = cfg.TLS
+				next.Timeouts = cfg.Timeouts
+				next.IDFile = cfg.IDFile
+			}
+
+			srv.Reload(next)
+			if err := broadcaster.Reload(next); err != nil {
+				log.Printf("Failed to update mDNS registration: %v", err)
+			}
+
+			cfg = next
+			log.Println("Configuration reloaded")
+		}
+	}()
+
+	// Listen before serving in the background so a signal arriving early
+	// still finds a server to shut down
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	if err := srv.Listen(); err != nil {
 		broadcaster.Stop()
-		os.Exit(0)
+		log.Fatalf("Server failed: %v", err)
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.Serve()
 	}()
 
-	// Start server (blocking)
-	if err := srv.Start(); err != nil {
+	// Wait for a shutdown signal or a server failure
+	select {
+	case sig := <-sigChan:
+		log.Printf("Received %v, shutting down agent...", sig)
+	case err := <-serverErr:
+		broadcaster.Stop()
 		log.Fatalf("Server failed: %v", err)
 	}
+
+	// Deregister first so dashboards stop discovering us, then drain requests
+	broadcaster.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Graceful shutdown incomplete: %v", err)
+	}
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Server error: %v", err)
+	}
+	log.Println("Agent stopped")
+}
+
+// loadConfig reads the config file, applies env and flag overrides and validates the result
+func loadConfig(path string, overrides func(*config.Config)) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	overrides(cfg)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
 func getHostname() string {
@@ -60,4 +157,4 @@ func getHostname() string {
 		return "unknown"
 	}
 	return hostname
-}
\ No newline at end of file
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/AzertoxHDW/sentinel/agent/collector"
+	"github.com/AzertoxHDW/sentinel/agent/telemetry"
+)
+
+// GET /metrics/prometheus - Host and agent metrics in the Prometheus text format
+func (s *Server) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metrics, err := s.collector.Collect()
+	if err != nil {
+		log.Printf("Error collecting metrics: %v", err)
+		http.Error(w, "Failed to collect metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	writeHostMetrics(bw, metrics)
+	writeAgentMetrics(bw, s.telemetry.Snapshot())
+	if err := bw.Flush(); err != nil {
+		log.Printf("Error writing prometheus metrics: %v", err)
+	}
+}
+
+func writeHostMetrics(w io.Writer, m *collector.SystemMetrics) {
+	host := label("hostname", m.Hostname)
+
+	gauge(w, "sentinel_uptime_seconds", "Host uptime in seconds.", host, float64(m.Uptime))
+	gauge(w, "sentinel_cpu_usage_percent", "CPU usage percentage.", host, m.CPU.UsagePercent)
+	gauge(w, "sentinel_cpu_cores", "Number of logical CPU cores.", host, float64(m.CPU.CoreCount))
+	if len(m.CPU.LoadAvg) == 3 {
+		header(w, "sentinel_load_average", "gauge", "System load average.")
+		for i, period := range []string{"1m", "5m", "15m"} {
+			sample(w, "sentinel_load_average", host+","+label("period", period), m.CPU.LoadAvg[i])
+		}
+	}
+
+	gauge(w, "sentinel_memory_total_bytes", "Total memory in bytes.", host, float64(m.Memory.Total))
+	gauge(w, "sentinel_memory_used_bytes", "Used memory in bytes.", host, float64(m.Memory.Used))
+	gauge(w, "sentinel_memory_available_bytes", "Available memory in bytes.", host, float64(m.Memory.Available))
+
+	if len(m.Disk) > 0 {
+		header(w, "sentinel_disk_total_bytes", "gauge", "Filesystem size in bytes.")
+		for _, d := range m.Disk {
+			sample(w, "sentinel_disk_total_bytes", diskLabels(host, d), float64(d.Total))
+		}
+		header(w, "sentinel_disk_used_bytes", "gauge", "Filesystem used bytes.")
+		for _, d := range m.Disk {
+			sample(w, "sentinel_disk_used_bytes", diskLabels(host, d), float64(d.Used))
+		}
+		header(w, "sentinel_disk_free_bytes", "gauge", "Filesystem free bytes.")
+		for _, d := range m.Disk {
+			sample(w, "sentinel_disk_free_bytes", diskLabels(host, d), float64(d.Free))
+		}
+	}
+
+	if len(m.Network) > 0 {
+		counters := []struct {
+			name, help string
+			value      func(collector.NetworkMetrics) uint64
+		}{
+			{"sentinel_network_sent_bytes_total", "Bytes sent.", func(n collector.NetworkMetrics) uint64 { return n.BytesSent }},
+			{"sentinel_network_received_bytes_total", "Bytes received.", func(n collector.NetworkMetrics) uint64 { return n.BytesRecv }},
+			{"sentinel_network_sent_packets_total", "Packets sent.", func(n collector.NetworkMetrics) uint64 { return n.PacketsSent }},
+			{"sentinel_network_received_packets_total", "Packets received.", func(n collector.NetworkMetrics) uint64 { return n.PacketsRecv }},
+		}
+		for _, c := range counters {
+			header(w, c.name, "counter", c.help)
+			for _, n := range m.Network {
+				sample(w, c.name, host+","+label("interface", n.Interface), float64(c.value(n)))
+			}
+		}
+	}
+}
+
+func writeAgentMetrics(w io.Writer, stats telemetry.Stats) {
+	b := stats.Build
+	gauge(w, "sentinel_agent_build_info", "Agent build information.",
+		strings.Join([]string{
+			label("version", b.Version),
+			label("go_version", b.GoVersion),
+			label("revision", b.Revision),
+			label("os", b.OS),
+			label("arch", b.Arch),
+		}, ","), 1)
+	gauge(w, "sentinel_agent_uptime_seconds", "Seconds since the agent started.", "", stats.UptimeSeconds)
+
+	healthy := 1.0
+	if stats.Status != "ok" {
+		healthy = 0
+	}
+	gauge(w, "sentinel_agent_healthy", "Whether every collector succeeded on its last run.", "", healthy)
+
+	names := sortedKeys(stats.Collectors)
+	header(w, "sentinel_agent_collector_duration_seconds", "gauge", "Duration of the last run per collector.")
+	for _, name := range names {
+		sample(w, "sentinel_agent_collector_duration_seconds", label("collector", name), stats.Collectors[name].LastDuration.Seconds())
+	}
+	header(w, "sentinel_agent_collector_runs_total", "counter", "Collector runs.")
+	for _, name := range names {
+		sample(w, "sentinel_agent_collector_runs_total", label("collector", name), float64(stats.Collectors[name].Runs))
+	}
+	header(w, "sentinel_agent_collector_errors_total", "counter", "Collector errors.")
+	for _, name := range names {
+		sample(w, "sentinel_agent_collector_errors_total", label("collector", name), float64(stats.Collectors[name].Errors))
+	}
+
+	header(w, "sentinel_agent_requests_total", "counter", "HTTP requests by endpoint.")
+	for _, endpoint := range sortedKeys(stats.Requests) {
+		sample(w, "sentinel_agent_requests_total", label("endpoint", endpoint), float64(stats.Requests[endpoint]))
+	}
+
+	rt := stats.Runtime
+	gauge(w, "sentinel_agent_goroutines", "Number of goroutines.", "", float64(rt.Goroutines))
+	gauge(w, "sentinel_agent_resident_memory_bytes", "Resident set size in bytes.", "", float64(rt.RSSBytes))
+	gauge(w, "sentinel_agent_heap_alloc_bytes", "Allocated heap bytes.", "", float64(rt.HeapAllocBytes))
+	header(w, "sentinel_agent_gc_runs_total", "counter", "Completed GC cycles.")
+	sample(w, "sentinel_agent_gc_runs_total", "", float64(rt.GCCount))
+	header(w, "sentinel_agent_gc_pause_seconds_total", "counter", "Total GC pause time.")
+	sample(w, "sentinel_agent_gc_pause_seconds_total", "", float64(rt.GCPauseTotalNs)/1e9)
+}
+
+func gauge(w io.Writer, name, help, labels string, value float64) {
+	header(w, name, "gauge", help)
+	sample(w, name, labels, value)
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(w io.Writer, name, labels string, value float64) {
+	if labels == "" {
+		fmt.Fprintf(w, "%s %g\n", name, value)
+		return
+	}
+	fmt.Fprintf(w, "%s{%s} %g\n", name, labels, value)
+}
+
+func diskLabels(host string, d collector.DiskMetrics) string {
+	return strings.Join([]string{host, label("device", d.Device), label("mount_point", d.MountPoint), label("fs_type", d.FSType)}, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
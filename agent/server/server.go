@@ -1,37 +1,173 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/AzertoxHDW/sentinel/agent/collector"
+	"github.com/AzertoxHDW/sentinel/agent/config"
+	"github.com/AzertoxHDW/sentinel/agent/telemetry"
+	"github.com/AzertoxHDW/sentinel/agent/version"
 )
 
 type Server struct {
-	collector *collector.Collector
-	port      string
+	collector  *collector.Collector
+	telemetry  *telemetry.Telemetry
+	config     *config.Config
+	httpServer *http.Server
+	listener   net.Listener
+	done       chan struct{}
+	mu         sync.RWMutex
 }
 
-func NewServer(port string) (*Server, error) {
-	col, err := collector.NewCollector()
+const (
+	defaultStreamInterval = 5 * time.Second
+	minStreamInterval     = time.Second
+	maxStreamInterval     = 5 * time.Minute
+)
+
+func NewServer(cfg *config.Config, agentID string) (*Server, error) {
+	tel := telemetry.New()
+	col, err := collector.NewCollector(agentID, cfg.Collectors, tel)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Server{
 		collector: col,
-		port:      port,
+		telemetry: tel,
+		config:    cfg,
+		done:      make(chan struct{}),
 	}, nil
 }
 
-func (s *Server) Start() error {
-	http.HandleFunc("/metrics", s.handleMetrics)
-	http.HandleFunc("/health", s.handleHealth)
+// Listen opens the listening socket and sets up the HTTP server without
+// serving yet, so a Shutdown from then on is never lost
+func (s *Server) Listen() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.requireAuth(s.handleMetrics))
+	mux.HandleFunc("/metrics/stream", s.requireAuth(s.handleStream))
+	mux.HandleFunc("/metrics/prometheus", s.requireAuth(s.handlePrometheus))
+	mux.HandleFunc("/agent/stats", s.requireAuth(s.handleStats))
+	mux.HandleFunc("/health", s.handleHealth)
+
+	cfg := s.currentConfig()
+	listener, err := listen(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.httpServer = &http.Server{
+		Handler:      s.countRequests(mux),
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+	}
+	// Streams never finish on their own; end them so Shutdown can drain
+	s.httpServer.RegisterOnShutdown(func() { close(s.done) })
+	s.listener = listener
+	s.mu.Unlock()
+	return nil
+}
+
+// Serve accepts connections on the listener opened by Listen until
+// Shutdown; after a Shutdown it returns http.ErrServerClosed at once
+func (s *Server) Serve() error {
+	s.mu.RLock()
+	httpServer, listener, cfg := s.httpServer, s.listener, s.config
+	s.mu.RUnlock()
+
+	if httpServer == nil {
+		return fmt.Errorf("server is not listening")
+	}
+
+	if cfg.TLSEnabled() {
+		log.Printf("Agent server starting on %s (TLS)", cfg.Listen)
+		return httpServer.ServeTLS(listener, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	}
+
+	log.Printf("Agent server starting on %s", cfg.Listen)
+	return httpServer.Serve(listener)
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// to finish or ctx to expire
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.RLock()
+	httpServer := s.httpServer
+	s.mu.RUnlock()
+
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+func listen(cfg *config.Config) (net.Listener, error) {
+	if path, ok := cfg.UnixSocket(); ok {
+		// Remove a stale socket left behind by an unclean exit
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", cfg.Listen)
+}
+
+// Reload applies a new configuration to the running server.
+// Listen address, TLS and timeout settings are only read on Start.
+func (s *Server) Reload(cfg *config.Config) {
+	s.mu.Lock()
+	s.config = cfg
+	s.mu.Unlock()
+
+	s.collector.SetConfig(cfg.Collectors)
+}
+
+func (s *Server) currentConfig() *config.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// countRequests records a request counter per registered endpoint
+func (s *Server) countRequests(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unknown paths share one label to keep the counter set bounded
+		endpoint := "other"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			endpoint = pattern
+		}
+		s.telemetry.ObserveRequest(endpoint)
+		mux.ServeHTTP(w, r)
+	})
+}
 
-	log.Printf("Agent server starting on :%s", s.port)
-	return http.ListenAndServe(":"+s.port, nil)
+// requireAuth checks the bearer token when one is configured
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.currentConfig().Auth.Token
+		if token != "" {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="sentinel"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
 }
 
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
@@ -58,14 +194,99 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /metrics/stream?interval=5s - Push samples as Server-Sent Events
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	interval := defaultStreamInterval
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minStreamInterval || d > maxStreamInterval {
+			http.Error(w, fmt.Sprintf("interval must be a duration between %v and %v", minStreamInterval, maxStreamInterval), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	// The server write timeout would cut the stream short
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error disabling write deadline for stream: %v", err)
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		metrics, err := s.collector.Collect()
+		if err != nil {
+			log.Printf("Error collecting metrics: %v", err)
+			fmt.Fprintf(w, "event: error\ndata: %q\n\n", "failed to collect metrics")
+		} else {
+			data, err := json.Marshal(metrics)
+			if err != nil {
+				log.Printf("Error encoding metrics: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// GET /agent/stats - The agent's own health and runtime statistics
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(s.telemetry.Snapshot()); err != nil {
+		log.Printf("Error encoding agent stats: %v", err)
+	}
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
+	// Identity fields mirror the mDNS TXT records so the dashboard can
+	// recognise agents found by a subnet sweep
+	cfg := s.currentConfig()
 	health := map[string]interface{}{
-		"status":    "ok",
-		"timestamp": time.Now(),
+		"status":        "ok",
+		"agent_id":      s.collector.AgentID(),
+		"hostname":      s.collector.Hostname(),
+		"version":       version.Version,
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"tls":           cfg.TLSEnabled(),
+		"auth_required": cfg.Auth.Token != "",
+		"tags":          cfg.MDNS.Tags,
+		"timestamp":     time.Now(),
 	}
 
 	json.NewEncoder(w).Encode(health)
-}
\ No newline at end of file
+}
@@ -0,0 +1,138 @@
+package telemetry
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/AzertoxHDW/sentinel/agent/version"
+)
+
+// Telemetry tracks the agent's own behaviour
+type Telemetry struct {
+	started    time.Time
+	collectors map[string]*CollectorStats
+	requests   map[string]uint64
+	mu         sync.Mutex
+}
+
+// CollectorStats holds timing and error counters for one collector
+type CollectorStats struct {
+	Runs         uint64        `json:"runs"`
+	Errors       uint64        `json:"errors"`
+	LastDuration time.Duration `json:"last_duration_ns"`
+	MaxDuration  time.Duration `json:"max_duration_ns"`
+	LastError    string        `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time    `json:"last_error_at,omitempty"`
+	// LastFailed is true when the most recent run returned an error
+	LastFailed bool `json:"last_failed"`
+}
+
+// Stats is a point-in-time snapshot of the agent's health
+type Stats struct {
+	Status        string                    `json:"status"` // ok, degraded
+	Build         version.BuildInfo         `json:"build"`
+	StartedAt     time.Time                 `json:"started_at"`
+	UptimeSeconds float64                   `json:"uptime_seconds"`
+	Collectors    map[string]CollectorStats `json:"collectors"`
+	Requests      map[string]uint64         `json:"requests"`
+	Runtime       RuntimeStats              `json:"runtime"`
+}
+
+type RuntimeStats struct {
+	Goroutines     int     `json:"goroutines"`
+	RSSBytes       uint64  `json:"rss_bytes"`
+	HeapAllocBytes uint64  `json:"heap_alloc_bytes"`
+	HeapSysBytes   uint64  `json:"heap_sys_bytes"`
+	GCCount        uint32  `json:"gc_count"`
+	GCPauseTotalNs uint64  `json:"gc_pause_total_ns"`
+	GCLastPauseNs  uint64  `json:"gc_last_pause_ns"`
+	GCCPUFraction  float64 `json:"gc_cpu_fraction"`
+}
+
+func New() *Telemetry {
+	return &Telemetry{
+		started:    time.Now(),
+		collectors: make(map[string]*CollectorStats),
+		requests:   make(map[string]uint64),
+	}
+}
+
+// ObserveCollection records one run of the named collector
+func (t *Telemetry) ObserveCollection(name string, duration time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats, ok := t.collectors[name]
+	if !ok {
+		stats = &CollectorStats{}
+		t.collectors[name] = stats
+	}
+
+	stats.Runs++
+	stats.LastDuration = duration
+	if duration > stats.MaxDuration {
+		stats.MaxDuration = duration
+	}
+	stats.LastFailed = err != nil
+	if err != nil {
+		stats.Errors++
+		stats.LastError = err.Error()
+		now := time.Now()
+		stats.LastErrorAt = &now
+	}
+}
+
+// ObserveRequest counts one HTTP request to the given endpoint
+func (t *Telemetry) ObserveRequest(endpoint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests[endpoint]++
+}
+
+// Snapshot returns the current stats
+func (t *Telemetry) Snapshot() Stats {
+	t.mu.Lock()
+	stats := Stats{
+		Status:        "ok",
+		Build:         version.Info(),
+		StartedAt:     t.started,
+		UptimeSeconds: time.Since(t.started).Seconds(),
+		Collectors:    make(map[string]CollectorStats, len(t.collectors)),
+		Requests:      make(map[string]uint64, len(t.requests)),
+	}
+	for name, c := range t.collectors {
+		stats.Collectors[name] = *c
+		if c.LastFailed {
+			stats.Status = "degraded"
+		}
+	}
+	for endpoint, count := range t.requests {
+		stats.Requests[endpoint] = count
+	}
+	t.mu.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats.Runtime = RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapSysBytes:   mem.HeapSys,
+		GCCount:        mem.NumGC,
+		GCPauseTotalNs: mem.PauseTotalNs,
+		GCLastPauseNs:  mem.PauseNs[(mem.NumGC+255)%256],
+		GCCPUFraction:  mem.GCCPUFraction,
+	}
+
+	if proc, err := process.NewProcess(int32(os.Getpid())); err == nil {
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			stats.Runtime.RSSBytes = memInfo.RSS
+		}
+	}
+
+	return stats
+}
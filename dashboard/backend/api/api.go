@@ -1,35 +1,54 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/collector"
 	"github.com/AzertoxHDW/sentinel/dashboard/backend/discovery"
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/remotewrite"
 	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/stream"
 )
 
 type Server struct {
-	store      *storage.Store
-	scanner    *discovery.Scanner
-	influxDB   *storage.InfluxDB
+	store      storage.Store
+	scanner    discovery.Source
+	watcher    *discovery.Watcher
+	collector  *collector.MetricsCollector
+	remote     *remotewrite.Writer
+	metrics    storage.MetricsStore
+	streams    *stream.Hub
 	port       string
 	httpClient *http.Client
+	// Short timeout client for reachability probes
+	probeClient *http.Client
+	// Sent to agents that require a token when none was given for them
+	agentToken string
 }
 
-func NewServer(store *storage.Store, influxDB *storage.InfluxDB, port string) *Server {
+func NewServer(store storage.Store, metrics storage.MetricsStore, scanner discovery.Source, port string) *Server {
 	return &Server{
-		store:    store,
-		scanner:  discovery.NewScanner(),
-		influxDB: influxDB,
-		port:     port,
+		store:   store,
+		scanner: scanner,
+		metrics: metrics,
+		streams: stream.NewHub(nil),
+		port:    port,
 		httpClient: &http.Client{
 			Timeout: 5 * time.Second,
 		},
+		probeClient: &http.Client{
+			Timeout: 2 * time.Second,
+		},
 	}
 }
 
@@ -41,11 +60,19 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/api/agents", s.handleAgents)
 	mux.HandleFunc("/api/agents/", s.handleAgent)
 	mux.HandleFunc("/api/agents/discover", s.handleDiscover)
+	mux.HandleFunc("/api/discovery", s.handleDiscovery)
+	mux.HandleFunc("/api/availability", s.handleAvailability)
+	mux.HandleFunc("/api/groups", s.handleGroups)
+	mux.HandleFunc("/api/groups/", s.handleGroup)
 
 	// Metrics proxy endpoint
 	mux.HandleFunc("/api/metrics/", s.handleMetrics)
 
+	// Live metrics stream (Server-Sent Events)
+	mux.HandleFunc("/api/stream/", s.handleStream)
+
 	// History endpoint
+	mux.HandleFunc("/api/history", s.handleFleetHistory)
 	mux.HandleFunc("/api/history/", s.handleHistory)
 
 	// Health check
@@ -61,7 +88,7 @@ func (s *Server) Start() error {
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -73,69 +100,63 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Helper to normalize hostnames for comparison
-// Removes trailing dots, converts to lowercase, and removes domain suffixes
-// Example: "MyServer.local." -> "myserver"
-func normalizeHostname(h string) string {
-	h = strings.ToLower(strings.TrimSpace(h))
-	h = strings.TrimSuffix(h, ".")
-	if idx := strings.Index(h, "."); idx != -1 {
-		return h[:idx]
-	}
-	return h
-}
-
-// GET /api/agents - List all agents
+// GET /api/agents - List agents, optionally filtered by ?tag=, ?group=, ?status=
 // POST /api/agents - Add new agent
 func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		agents := s.store.GetAllAgents()
+		filter := parseAgentFilter(r.URL.Query())
+		agents := make([]*storage.Agent, 0)
+		for _, agent := range s.store.GetAllAgents() {
+			if filter.matches(agent) {
+				agents = append(agents, agent)
+			}
+		}
 		s.respondJSON(w, http.StatusOK, agents)
 
 	case http.MethodPost:
-		// Decode only IP and port from request
+		// Decode only addresses, port and connection settings from request
 		var req struct {
-			IPAddress string `json:"ip_address"`
-			Port      int    `json:"port"`
+			IPAddress   string   `json:"ip_address"`
+			IPAddresses []string `json:"ip_addresses"`
+			Family      string   `json:"address_family"`
+			Port        int      `json:"port"`
+			// Omitted tls tries http, then https
+			TLS       *bool  `json:"tls"`
+			AuthToken string `json:"auth_token"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			s.respondError(w, http.StatusBadRequest, "Invalid request body")
 			return
 		}
-
-		// Fetch actual hostname from the agent
-		metricsURL := fmt.Sprintf("http://%s:%d/metrics", req.IPAddress, req.Port)
-		resp, err := s.httpClient.Get(metricsURL)
-		if err != nil {
-			log.Printf("Failed to reach agent at %s: %v", metricsURL, err)
-			s.respondError(w, http.StatusServiceUnavailable, "Cannot reach agent")
+		if !storage.ValidFamily(req.Family) {
+			s.respondError(w, http.StatusBadRequest, "address_family must be auto, ipv4 or ipv6")
 			return
 		}
-		defer resp.Body.Close()
 
-		// Parse metrics to get hostname
-		var metricsResp struct {
-			Hostname string `json:"hostname"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&metricsResp); err != nil {
-			log.Printf("Failed to parse agent metrics: %v", err)
-			s.respondError(w, http.StatusInternalServerError, "Invalid agent response")
-			return
+		// IPv4 and IPv6 literals and hostnames are accepted, ordered by family
+		// preference
+		candidates := req.IPAddresses
+		if req.IPAddress != "" {
+			candidates = append([]string{req.IPAddress}, candidates...)
 		}
 
-		// Create agent with real hostname
-		agent := &storage.Agent{
-			Hostname:  metricsResp.Hostname,
-			IPAddress: req.IPAddress,
-			Port:      req.Port,
-			ID:        fmt.Sprintf("%s:%d", metricsResp.Hostname, req.Port),
+		conn := storage.Connection{AuthToken: strings.TrimSpace(req.AuthToken)}
+		if req.TLS != nil {
+			conn.Scheme = storage.SchemeFor(*req.TLS)
 		}
 
-		log.Printf("Adding agent: ID=%s, Hostname=%s, IP=%s:%d",
-			agent.ID, agent.Hostname, agent.IPAddress, agent.Port)
-
-		if err := s.store.AddAgent(agent); err != nil {
+		agent, err := s.registerAgent(candidates, req.Family, req.Port, conn)
+		if err != nil {
+			var regErr *registrationError
+			if errors.As(err, &regErr) {
+				s.respondError(w, regErr.status, regErr.message)
+				return
+			}
+			if errors.Is(err, storage.ErrAgentExists) {
+				s.respondError(w, http.StatusConflict, "Agent already registered")
+				return
+			}
 			s.respondError(w, http.StatusInternalServerError, "Failed to add agent")
 			return
 		}
@@ -147,19 +168,300 @@ func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// registrationError carries the HTTP status for a failed registration
+type registrationError struct {
+	status  int
+	message string
+}
+
+func (e *registrationError) Error() string { return e.message }
+
+// registerAgent contacts the agent at the first reachable address, identifies
+// it and stores it. An empty conn.Scheme tries http, then https; without a
+// conn.AuthToken the default agent token is used if the agent asks for one.
+func (s *Server) registerAgent(candidates []string, family string, port int, conn storage.Connection) (*storage.Agent, error) {
+	addresses := storage.SortAddresses(resolveAddresses(candidates), family)
+	if len(addresses) == 0 {
+		return nil, &registrationError{http.StatusBadRequest, "No valid or resolvable address for the requested address family"}
+	}
+
+	// Keep only addresses that answer; the first one becomes active
+	schemes := []string{conn.Scheme}
+	if conn.Scheme == "" {
+		schemes = []string{storage.SchemeHTTP, storage.SchemeHTTPS}
+	}
+	var reachable []string
+	for _, scheme := range schemes {
+		if reachable = discovery.Probe(s.probeClient, scheme, addresses, port); len(reachable) > 0 {
+			conn.Scheme = scheme
+			break
+		}
+	}
+	if len(reachable) == 0 {
+		log.Printf("No reachable address for agent at %v port %d", addresses, port)
+		return nil, &registrationError{http.StatusServiceUnavailable, "Cannot reach agent"}
+	}
+
+	// Fetch actual hostname from the first address that answers
+	ipAddress, identity, err := s.identifyAgent(reachable, port, conn)
+	if errors.Is(err, errAgentUnauthorized) && conn.AuthToken == "" && s.agentToken != "" {
+		conn.AuthToken = s.agentToken
+		ipAddress, identity, err = s.identifyAgent(reachable, port, conn)
+	}
+	if errors.Is(err, errAgentUnauthorized) {
+		log.Printf("Agent at %v port %d rejected the auth token", addresses, port)
+		return nil, &registrationError{http.StatusBadRequest, "Agent requires a valid auth_token"}
+	}
+	if err != nil {
+		log.Printf("Failed to reach agent at %v port %d: %v", addresses, port, err)
+		return nil, &registrationError{http.StatusServiceUnavailable, "Cannot reach agent"}
+	}
+
+	// Identify the agent by its UUID so renames don't create a new agent;
+	// older agents without one fall back to hostname:port
+	id := identity.AgentID
+	if id == "" {
+		id = storage.LegacyID(identity.Hostname, port)
+	}
+
+	// Create agent with real hostname
+	agent := &storage.Agent{
+		Hostname:   identity.Hostname,
+		IPAddress:  ipAddress,
+		Addresses:  addresses,
+		Family:     family,
+		Port:       port,
+		ID:         id,
+		Connection: conn,
+	}
+
+	log.Printf("Adding agent: ID=%s, Hostname=%s, URL=%s",
+		agent.ID, agent.Hostname, agent.BaseURL())
+
+	if err := s.store.AddAgent(agent); err != nil {
+		return nil, err
+	}
+
+	return agent, nil
+}
+
+// resolveAddresses replaces hostnames among candidates with the addresses
+// they resolve to; IP literals are kept as given
+func resolveAddresses(candidates []string) []string {
+	var addresses []string
+	for _, candidate := range candidates {
+		if _, err := netip.ParseAddr(candidate); err == nil {
+			addresses = append(addresses, candidate)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, candidate)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to resolve %s: %v", candidate, err)
+			continue
+		}
+		for _, ip := range ips {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses
+}
+
+// SetAgentTransport sends all agent requests through transport, e.g. to
+// trust the agents' CA. Call it before Start and StartDiscovery.
+func (s *Server) SetAgentTransport(transport http.RoundTripper) {
+	s.httpClient.Transport = transport
+	s.probeClient.Transport = transport
+	s.streams = stream.NewHub(transport)
+}
+
+// SetAgentToken sets the token registered agents are given when they
+// require one and the request didn't supply it
+func (s *Server) SetAgentToken(token string) {
+	s.agentToken = token
+}
+
+// SetRemoteWrite reports the remote-write queue in /api/health
+func (s *Server) SetRemoteWrite(w *remotewrite.Writer) {
+	s.remote = w
+}
+
+// SetCollector exposes the collector's scheduler metrics in /api/health
+func (s *Server) SetCollector(c *collector.MetricsCollector) {
+	s.collector = c
+}
+
+// StartDiscovery runs mDNS discovery in the background
+func (s *Server) StartDiscovery(config discovery.WatcherConfig) {
+	s.watcher = discovery.NewWatcher(s.scanner, s.store, s.probeClient, config, func(disc *discovery.DiscoveredAgent) (*storage.Agent, error) {
+		var conn storage.Connection
+		if disc.TLS {
+			conn.Scheme = storage.SchemeHTTPS
+		}
+		agent, err := s.registerAgent(disc.IPs, storage.FamilyAuto, disc.Port, conn)
+		if err != nil || len(disc.Tags) == 0 {
+			return agent, err
+		}
+
+		// Start from the tags the agent advertises
+		tags, err := normalizeLabels("tags", disc.Tags)
+		if err != nil {
+			log.Printf("Ignoring advertised tags of %s: %v", agent.ID, err)
+			return agent, nil
+		}
+		if updated, err := s.store.UpdateAgentMetadata(agent.ID, func(meta *storage.AgentMetadata) {
+			meta.Tags = tags
+		}); err == nil {
+			agent = updated
+		}
+		return agent, nil
+	})
+	s.watcher.Start()
+}
+
+// StopDiscovery stops background discovery if it was started
+func (s *Server) StopDiscovery() {
+	if s.watcher != nil {
+		s.watcher.Stop()
+	}
+}
+
+// agentIdentity is the part of the agent payload used to register it
+type agentIdentity struct {
+	AgentID  string `json:"agent_id"`
+	Hostname string `json:"hostname"`
+}
+
+// errAgentUnauthorized means the agent refused the token, or its absence
+var errAgentUnauthorized = errors.New("agent requires a valid auth token")
+
+// identifyAgent tries each address in order and returns the first one
+// serving a valid agent payload
+func (s *Server) identifyAgent(addresses []string, port int, conn storage.Connection) (string, *agentIdentity, error) {
+	var lastErr error
+	for _, addr := range addresses {
+		metricsURL := storage.BaseURL(conn.Scheme, addr, port) + "/metrics"
+		req, err := http.NewRequest(http.MethodGet, metricsURL, nil)
+		if err != nil {
+			return "", nil, err
+		}
+		conn.Authorize(req)
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			return "", nil, errAgentUnauthorized
+		}
+		// Another service on the port may well answer with JSON too
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status %s from %s", resp.Status, metricsURL)
+			continue
+		}
+
+		var identity agentIdentity
+		err = json.NewDecoder(resp.Body).Decode(&identity)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("invalid agent response from %s: %w", metricsURL, err)
+			continue
+		}
+		if identity.AgentID == "" && identity.Hostname == "" {
+			lastErr = fmt.Errorf("no agent identity in the response from %s", metricsURL)
+			continue
+		}
+
+		return addr, &identity, nil
+	}
+	return "", nil, lastErr
+}
+
+// GET /api/agents/{id} - Get agent
+// PUT /api/agents/{id} - Replace agent metadata
+// PATCH /api/agents/{id} - Update some agent metadata fields
 // DELETE /api/agents/{id} - Remove agent
+// GET /api/agents/{id}/events, /api/agents/{id}/availability - Status history
+// POST /api/agents/{id}/pause, /api/agents/{id}/resume - Suspend or resume polling
 func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
-	// Extract ID from path
-	id := r.URL.Path[len("/api/agents/"):]
+	// Extract ID and optional sub-resource from path
+	id, sub, _ := strings.Cut(r.URL.Path[len("/api/agents/"):], "/")
 
 	if id == "" {
 		s.respondError(w, http.StatusBadRequest, "Agent ID required")
 		return
 	}
 
+	if sub != "" {
+		method := http.MethodGet
+		if sub == "pause" || sub == "resume" {
+			method = http.MethodPost
+		}
+		if r.Method != method {
+			s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		switch sub {
+		case "events":
+			s.handleAgentEvents(w, r, id)
+		case "availability":
+			s.handleAgentAvailability(w, r, id)
+		case "pause", "resume":
+			s.handleAgentPause(w, id, sub == "pause")
+		default:
+			s.respondError(w, http.StatusNotFound, "Not found")
+		}
+		return
+	}
+
 	switch r.Method {
+	case http.MethodGet:
+		agent, exists := s.store.GetAgent(id)
+		if !exists {
+			s.respondError(w, http.StatusNotFound, "Agent not found")
+			return
+		}
+		s.respondJSON(w, http.StatusOK, agent)
+
+	case http.MethodPut, http.MethodPatch:
+		var req metadataRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := req.validate(); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		agent, err := s.store.UpdateAgentSettings(id, func(conn *storage.Connection, meta *storage.AgentMetadata) {
+			req.applyConnection(conn)
+			req.apply(meta, r.Method == http.MethodPut)
+		})
+		if errors.Is(err, storage.ErrAgentNotFound) {
+			s.respondError(w, http.StatusNotFound, "Agent not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to update agent %s: %v", id, err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to update agent")
+			return
+		}
+		s.respondJSON(w, http.StatusOK, agent)
+
 	case http.MethodDelete:
-		if err := s.store.RemoveAgent(id); err != nil {
+		if err := s.store.RemoveAgent(id); errors.Is(err, storage.ErrAgentNotFound) {
+			s.respondError(w, http.StatusNotFound, "Agent not found")
+			return
+		} else if err != nil {
+			log.Printf("Failed to remove agent %s: %v", id, err)
 			s.respondError(w, http.StatusInternalServerError, "Failed to remove agent")
 			return
 		}
@@ -170,6 +472,20 @@ func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) handleAgentPause(w http.ResponseWriter, id string, paused bool) {
+	agent, err := s.store.SetPaused(id, paused)
+	if errors.Is(err, storage.ErrAgentNotFound) {
+		s.respondError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to pause/resume agent %s: %v", id, err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to update agent")
+		return
+	}
+	s.respondJSON(w, http.StatusOK, agent)
+}
+
 // GET /api/agents/discover - Discover agents via mDNS
 func (s *Server) handleDiscover(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -177,61 +493,72 @@ func (s *Server) handleDiscover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	discovered, err := s.scanner.Scan(3 * time.Second)
-	if err != nil {
-		log.Printf("ERROR: Discovery failed: %v", err)
-		s.respondError(w, http.StatusInternalServerError, "Discovery failed")
-		return
-	}
+	var discovered []*discovery.DiscoveredAgent
+	if s.watcher != nil && !s.watcher.LastScan().IsZero() {
+		// Answer from the live table instead of blocking on a scan
+		for _, seen := range s.watcher.Present(discovery.StatePending) {
+			discovered = append(discovered, seen.DiscoveredAgent)
+		}
+	} else {
+		var err error
+		discovered, err = s.scanner.Scan(3 * time.Second)
+		if err != nil {
+			log.Printf("ERROR: Discovery failed: %v", err)
+			s.respondError(w, http.StatusInternalServerError, "Discovery failed")
+			return
+		}
 
-	log.Printf("Scanner found %d raw agents via mDNS", len(discovered))
+		log.Printf("Scanner found %d raw agents", len(discovered))
+	}
 
 	// Get already registered agents
 	registeredAgents := s.store.GetAllAgents()
-	
+
 	// Filter out already registered agents
 	var newAgents []*discovery.DiscoveredAgent
 	for _, disc := range discovered {
-		// Normalize discovered names for comparison
-		discInstanceNorm := normalizeHostname(disc.Instance)
-		discHostnameNorm := normalizeHostname(disc.Hostname)
-
-		log.Printf("checking discovered agent: Instance='%s' (norm: '%s'), Hostname='%s' (norm: '%s'), IPs=%v", 
-			disc.Instance, discInstanceNorm, disc.Hostname, discHostnameNorm, disc.IPs)
-
-		isRegistered := false
-		
-		for _, registered := range registeredAgents {
-			regHostnameNorm := normalizeHostname(registered.Hostname)
-
-			// 1. Check if Hostname matches (Primary ID check with normalization)
-			// Checks if "myserver" == "myserver" OR "myserver" == "myserver.local"
-			if regHostnameNorm == discInstanceNorm || regHostnameNorm == discHostnameNorm {
-				isRegistered = true
-				break
-			}
-
-			// 2. Check if any IP matches (Fallback)
-			for _, ip := range disc.IPs {
-				if registered.IPAddress == ip && registered.Port == disc.Port {
-					isRegistered = true
-					break
-				}
-			}
-			if isRegistered {
-				break
-			}
-		}
-		
-		if !isRegistered {
-			newAgents = append(newAgents, disc)
-		} else {
+		if agent, match := discovery.FindRegistered(disc, registeredAgents); agent != nil {
+			log.Printf("Discovered agent %s already registered as %s (matched by %s)", disc.Instance, agent.ID, match)
+			continue
 		}
+		newAgents = append(newAgents, disc)
 	}
 
 	s.respondJSON(w, http.StatusOK, newAgents)
 }
 
+// GET /api/discovery?state=pending - Live discovery table
+// States: pending, registered, adopted
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if s.watcher == nil {
+		s.respondError(w, http.StatusServiceUnavailable, "Background discovery is disabled")
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", discovery.StatePending, discovery.StateRegistered, discovery.StateAdopted:
+	default:
+		s.respondError(w, http.StatusBadRequest, "state must be pending, registered or adopted")
+		return
+	}
+
+	var lastScan *time.Time
+	if t := s.watcher.LastScan(); !t.IsZero() {
+		lastScan = &t
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"last_scan": lastScan,
+		"agents":    s.watcher.Agents(state),
+	})
+}
+
 // GET /api/metrics/{agentID} - Proxy metrics from agent
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -254,73 +581,134 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch metrics from agent
-	metricsURL := fmt.Sprintf("http://%s:%d/metrics", agent.IPAddress, agent.Port)
-	resp, err := s.httpClient.Get(metricsURL)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, agent.BaseURL()+"/metrics", nil)
 	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "Invalid agent address")
+		return
+	}
+	agent.Authorize(req)
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		// Status is left to the poller so one failed view doesn't flap it
 		log.Printf("Failed to fetch metrics from %s: %v", agentID, err)
-		s.store.UpdateAgentStatus(agentID, "offline")
 		s.respondError(w, http.StatusServiceUnavailable, "Agent unreachable")
 		return
 	}
 	defer resp.Body.Close()
 
-	// Update agent status
-	s.store.UpdateAgentStatus(agentID, "online")
-
 	// Proxy response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(resp.StatusCode)
 	io.Copy(w, resp.Body)
 }
 
-// GET /api/history/{agentID}/{measurement}?duration=1h - Get historical metrics
-func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+// GET /api/stream/{agentID}?interval=5s - Live metrics as Server-Sent Events
+// All viewers of an agent share a single upstream connection to it
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Parse URL: /api/history/{agentID}/{measurement}?duration=1h
-	path := r.URL.Path[len("/api/history/"):]
-	parts := strings.Split(path, "/")
-
-	if len(parts) < 2 {
-		s.respondError(w, http.StatusBadRequest, "Invalid path. Use /api/history/{agentID}/{measurement}")
+	agentID := r.URL.Path[len("/api/stream/"):]
+	if agentID == "" {
+		s.respondError(w, http.StatusBadRequest, "Agent ID required")
 		return
 	}
 
-	agentID := parts[0]
-	measurement := parts[1]
-
-	// Parse duration from query params (default 1 hour)
-	durationStr := r.URL.Query().Get("duration")
-	if durationStr == "" {
-		durationStr = "1h"
+	if _, exists := s.store.GetAgent(agentID); !exists {
+		s.respondError(w, http.StatusNotFound, "Agent not found")
+		return
 	}
 
-	duration, err := time.ParseDuration(durationStr)
-	if err != nil {
-		s.respondError(w, http.StatusBadRequest, "Invalid duration format")
-		return
+	interval := 5 * time.Second
+	if v := r.URL.Query().Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second || d > 5*time.Minute {
+			s.respondError(w, http.StatusBadRequest, "Interval must be a duration between 1s and 5m")
+			return
+		}
+		// Round so near-identical requests share an upstream
+		interval = d.Round(time.Second)
 	}
 
-	// Query InfluxDB
-	records, err := s.influxDB.QueryMetrics(agentID, measurement, duration)
-	if err != nil {
-		log.Printf("Failed to query metrics: %v", err)
-		s.respondError(w, http.StatusInternalServerError, "Failed to query metrics")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.respondError(w, http.StatusInternalServerError, "Streaming unsupported")
 		return
 	}
 
-	s.respondJSON(w, http.StatusOK, records)
+	// Looked up again on every reconnect, so a new address or token applies
+	endpoint := func() (string, string, error) {
+		agent, exists := s.store.GetAgent(agentID)
+		if !exists {
+			return "", "", storage.ErrAgentNotFound
+		}
+		return agent.BaseURL(), agent.AuthToken, nil
+	}
+	events, unsubscribe := s.streams.Subscribe(agentID, endpoint, interval)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, event.Data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
 }
 
 // Health check
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+	now := time.Now()
+	health := map[string]interface{}{
 		"status":    "ok",
-		"timestamp": time.Now(),
-	})
+		"timestamp": now,
+	}
+	var reasons []string
+	if s.collector != nil {
+		health["collector"] = s.collector.Stats()
+	}
+	if writes, ok := s.metrics.(storage.WriteReporter); ok {
+		stats := writes.WriteStats()
+		health["metrics_writes"] = stats
+		if writesFailing(stats.Retrying, stats.LastErrorAt, now) {
+			reasons = append(reasons, "metrics writes failing")
+		}
+	}
+	if s.remote != nil {
+		stats := s.remote.Stats()
+		health["remote_write"] = stats
+		if writesFailing(stats.Retrying, stats.LastErrorAt, now) {
+			reasons = append(reasons, "remote write failing")
+		}
+	}
+	if len(reasons) > 0 {
+		health["status"] = "degraded"
+		health["reasons"] = reasons
+	}
+	s.respondJSON(w, http.StatusOK, health)
+}
+
+// failedWriteWindow is how long a failed write keeps the health degraded
+const failedWriteWindow = 5 * time.Minute
+
+// writesFailing reports whether a write queue is retrying or failed a
+// write recently
+func writesFailing(retrying bool, lastErrorAt *time.Time, now time.Time) bool {
+	return retrying || (lastErrorAt != nil && now.Sub(*lastErrorAt) < failedWriteWindow)
 }
 
 // Helper: Respond with JSON
@@ -333,4 +721,4 @@ func (s *Server) respondJSON(w http.ResponseWriter, status int, data interface{}
 // Helper: Respond with error
 func (s *Server) respondError(w http.ResponseWriter, status int, message string) {
 	s.respondJSON(w, status, map[string]string{"error": message})
-}
\ No newline at end of file
+}
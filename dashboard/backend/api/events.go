@@ -0,0 +1,206 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+const (
+	defaultEventsRange = 24 * time.Hour
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+// Windows reported when none are requested
+var defaultAvailabilityWindows = []string{"24h", "7d", "30d"}
+
+// GET /api/agents/{id}/events?from=&to=&limit=&cursor= - Status changes, oldest first
+// Pass the returned "next" as cursor, with the same range, to get the
+// following page.
+func (s *Server) handleAgentEvents(w http.ResponseWriter, r *http.Request, id string) {
+	if _, exists := s.store.GetAgent(id); !exists {
+		s.respondError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	query := r.URL.Query()
+	from, to, err := parseTimeRange(query.Get("from"), query.Get("to"), defaultEventsRange)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := defaultEventsLimit
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxEventsLimit {
+			s.respondError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxEventsLimit))
+			return
+		}
+	}
+
+	events, next, err := s.store.StatusEvents(id, from, to, query.Get("cursor"), limit)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		s.respondError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read events for %s: %v", id, err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to read events")
+		return
+	}
+
+	availability, err := storage.AgentAvailability(s.store, id, from, to)
+	if err != nil {
+		log.Printf("Failed to compute availability for %s: %v", id, err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to read events")
+		return
+	}
+
+	var nextCursor *string
+	if next != "" {
+		nextCursor = &next
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"agent_id":     id,
+		"from":         from,
+		"to":           to,
+		"events":       events,
+		"next":         nextCursor,
+		"availability": availability,
+	})
+}
+
+// GET /api/agents/{id}/availability?windows=24h,7d,30d - Availability per window
+func (s *Server) handleAgentAvailability(w http.ResponseWriter, r *http.Request, id string) {
+	if _, exists := s.store.GetAgent(id); !exists {
+		s.respondError(w, http.StatusNotFound, "Agent not found")
+		return
+	}
+
+	windows, err := parseWindows(r.URL.Query().Get("windows"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := s.availability(id, windows, time.Now())
+	if err != nil {
+		log.Printf("Failed to compute availability for %s: %v", id, err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to compute availability")
+		return
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+// GET /api/availability?windows=24h,7d - Availability of every agent
+// Accepts the same tag, group and status filters as /api/agents.
+func (s *Server) handleAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	windows, err := parseWindows(r.URL.Query().Get("windows"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := parseAgentFilter(r.URL.Query())
+	now := time.Now()
+	result := make(map[string]map[string]storage.Availability)
+	for _, agent := range s.store.GetAllAgents() {
+		if !filter.matches(agent) {
+			continue
+		}
+		availability, err := s.availability(agent.ID, windows, now)
+		if err != nil {
+			log.Printf("Failed to compute availability for %s: %v", agent.ID, err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to compute availability")
+			return
+		}
+		result[agent.ID] = availability
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
+
+// availability computes one entry per window, keyed by the window as given
+func (s *Server) availability(id string, windows map[string]time.Duration, now time.Time) (map[string]storage.Availability, error) {
+	result := make(map[string]storage.Availability, len(windows))
+	for name, window := range windows {
+		availability, err := storage.AgentAvailability(s.store, id, now.Add(-window), now)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = availability
+	}
+	return result, nil
+}
+
+// parseTimeRange reads RFC 3339 bounds; to defaults to now and from to
+// fallback before to
+func parseTimeRange(rawFrom, rawTo string, fallback time.Duration) (time.Time, time.Time, error) {
+	to := time.Now()
+	if rawTo != "" {
+		t, err := time.Parse(time.RFC3339Nano, rawTo)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: use RFC 3339, e.g. 2024-01-02T15:04:05Z")
+		}
+		to = t
+	}
+
+	from := to.Add(-fallback)
+	if rawFrom != "" {
+		t, err := time.Parse(time.RFC3339Nano, rawFrom)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: use RFC 3339, e.g. 2024-01-02T15:04:05Z")
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// parseWindows reads a comma separated list of durations; a "d" suffix
+// means days
+func parseWindows(raw string) (map[string]time.Duration, error) {
+	names := defaultAvailabilityWindows
+	if raw != "" {
+		names = strings.Split(raw, ",")
+	}
+
+	windows := make(map[string]time.Duration, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		window, err := parseDuration(name)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid window %q", name)
+		}
+		windows[name] = window
+	}
+	return windows, nil
+}
+
+// parseDuration extends time.ParseDuration with days, e.g. "7d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
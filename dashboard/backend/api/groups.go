@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// GET /api/groups - List group settings
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	s.respondJSON(w, http.StatusOK, s.store.GetAllGroups())
+}
+
+// GET /api/groups/{name} - Get group settings
+// PUT /api/groups/{name} - Create or replace group settings
+// DELETE /api/groups/{name} - Remove group settings (agents keep their membership)
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Path[len("/api/groups/"):])
+	if name == "" {
+		s.respondError(w, http.StatusBadRequest, "Group name required")
+		return
+	}
+
+	// Settings are keyed by the case-folded name, like agent membership
+	key := strings.ToLower(name)
+
+	switch r.Method {
+	case http.MethodGet:
+		group, exists := s.store.GetGroup(key)
+		if !exists {
+			s.respondError(w, http.StatusNotFound, "Group not found")
+			return
+		}
+		s.respondJSON(w, http.StatusOK, group)
+
+	case http.MethodPut:
+		var req struct {
+			Thresholds *storage.Thresholds `json:"thresholds"`
+			Polling    *storage.Polling    `json:"polling"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if _, err := normalizeLabels("groups", []string{name}); err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Thresholds != nil {
+			if err := req.Thresholds.Validate(); err != nil {
+				s.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if req.Polling != nil {
+			if err := req.Polling.Validate(); err != nil {
+				s.respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		group := &storage.Group{Name: key, Thresholds: req.Thresholds, Polling: req.Polling}
+		if err := s.store.PutGroup(group); err != nil {
+			log.Printf("Failed to save group %s: %v", name, err)
+			s.respondError(w, http.StatusInternalServerError, "Failed to save group")
+			return
+		}
+		s.respondJSON(w, http.StatusOK, group)
+
+	case http.MethodDelete:
+		if err := s.store.RemoveGroup(key); err != nil {
+			s.respondError(w, http.StatusInternalServerError, "Failed to remove group")
+			return
+		}
+		s.respondJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+
+	default:
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// GET /api/history/{agentID}/{measurement} - Get historical metrics
+//
+//	?from=&to=        RFC 3339 range (default the last ?duration=, 1h)
+//	?window=5m        aggregation window, or ?points= to pick one (default 300)
+//	?aggregate=max    mean, min, max, last, p95, p99 or rate (counters)
+//	?field=           fields to return
+//	?mount_point=     tag filters, e.g. ?interface=eth0 for network
+//	?format=columns   compact series instead of one row per point
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Parse URL: /api/history/{agentID}/{measurement}
+	path := r.URL.Path[len("/api/history/"):]
+	parts := strings.Split(path, "/")
+
+	if len(parts) != 2 || parts[0] == "" {
+		s.respondError(w, http.StatusBadRequest, "Invalid path. Use /api/history/{agentID}/{measurement}")
+		return
+	}
+
+	query, format, err := parseHistoryQuery([]string{parts[0]}, parts[1], r.URL.Query())
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	history, err := s.metrics.QueryMetrics(query)
+	if err != nil {
+		if errors.Is(err, storage.ErrUnknownMeasurement) || errors.Is(err, storage.ErrUnknownField) || errors.Is(err, storage.ErrInvalidQuery) {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrNoMetricsStore) {
+			s.respondError(w, http.StatusNotImplemented, "History is not available: the dashboard runs with -metrics-backend=none")
+			return
+		}
+		log.Printf("Failed to query metrics: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to query metrics")
+		return
+	}
+
+	if format == "columns" {
+		s.respondJSON(w, http.StatusOK, history)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, historyRows(history))
+}
+
+// historyParams are the query parameters that aren't tag filters
+var historyParams = []string{"duration", "from", "to", "window", "points", "aggregate", "field", "format"}
+
+// GET /api/history?measurement=cpu - Compare the history of several agents
+//
+// Agents are selected with ?agent=id1,id2 (or agent=all) and/or the agent
+// filters ?group=, ?tag=, ?status=. Takes the same parameters as the
+// single-agent history plus ?fleet=min,max,mean,sum for the series
+// combined across agents. Always answers in the column format.
+func (s *Server) handleFleetHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params := r.URL.Query()
+	agents, err := s.selectAgents(params)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(agents) == 0 {
+		s.respondError(w, http.StatusNotFound, "No agents match the selection")
+		return
+	}
+
+	functions := queryList(params, "fleet")
+	if len(functions) == 0 {
+		functions = storage.FleetFunctions
+	}
+	if err := storage.ValidateFleetFunctions(functions); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ids := make([]string, len(agents))
+	names := make(map[string]string, len(agents))
+	for i, agent := range agents {
+		ids[i] = agent.ID
+		names[agent.ID] = agent.DisplayName
+		if names[agent.ID] == "" {
+			names[agent.ID] = agent.Hostname
+		}
+	}
+
+	query, format, err := parseHistoryQuery(ids, params.Get("measurement"), params, "measurement", "agent", "group", "tag", "status", "fleet")
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if format == "rows" {
+		s.respondError(w, http.StatusBadRequest, "Fleet history is only available in the columns format")
+		return
+	}
+	// Counters are compared and summed as rates unless asked otherwise
+	if params.Get("aggregate") == "" && countersOnly(query) {
+		query.Aggregate = storage.RateAggregate
+	}
+
+	history, err := s.metrics.QueryMetrics(query)
+	if err != nil {
+		if errors.Is(err, storage.ErrUnknownMeasurement) || errors.Is(err, storage.ErrUnknownField) || errors.Is(err, storage.ErrInvalidQuery) {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, storage.ErrNoMetricsStore) {
+			s.respondError(w, http.StatusNotImplemented, "History is not available: the dashboard runs with -metrics-backend=none")
+			return
+		}
+		log.Printf("Failed to query fleet metrics: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "Failed to query metrics")
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, storage.AlignHistory(history, ids, names, functions))
+}
+
+// countersOnly reports whether every field the query selects is a counter
+func countersOnly(query storage.HistoryQuery) bool {
+	m := storage.Measurements[query.Measurement]
+	fields := query.Fields
+	if len(fields) == 0 {
+		fields = m.Fields
+	}
+	for _, field := range fields {
+		if !slices.Contains(m.Counters, field) {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+// selectAgents resolves the agent selection of a fleet query. Explicit IDs
+// must exist; the agent filters narrow the selection further.
+func (s *Server) selectAgents(params url.Values) ([]*storage.Agent, error) {
+	ids := queryList(params, "agent")
+	filter := parseAgentFilter(params)
+	all := slices.Contains(ids, "all")
+	if len(ids) == 0 && len(filter.tags) == 0 && len(filter.groups) == 0 && len(filter.statuses) == 0 {
+		return nil, fmt.Errorf("select agents with agent=, group=, tag=, status= or agent=all")
+	}
+
+	var candidates []*storage.Agent
+	if all || len(ids) == 0 {
+		candidates = s.store.GetAllAgents()
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+	} else {
+		for _, id := range ids {
+			agent, exists := s.store.GetAgent(id)
+			if !exists {
+				return nil, fmt.Errorf("unknown agent %q", id)
+			}
+			if !slices.ContainsFunc(candidates, func(a *storage.Agent) bool { return a.ID == id }) {
+				candidates = append(candidates, agent)
+			}
+		}
+	}
+
+	agents := make([]*storage.Agent, 0, len(candidates))
+	for _, agent := range candidates {
+		if filter.matches(agent) {
+			agents = append(agents, agent)
+		}
+	}
+	return agents, nil
+}
+
+// parseHistoryQuery reads the history parameters; the storage layer
+// validates the names and limits. Parameters other than historyParams and
+// reserved are tag filters.
+func parseHistoryQuery(agentIDs []string, measurement string, params url.Values, reserved ...string) (storage.HistoryQuery, string, error) {
+	query := storage.HistoryQuery{
+		AgentIDs:    agentIDs,
+		Measurement: measurement,
+		Fields:      queryList(params, "field"),
+		Aggregate:   params.Get("aggregate"),
+	}
+
+	format := params.Get("format")
+	if format != "" && format != "rows" && format != "columns" {
+		return query, "", fmt.Errorf("format must be rows or columns")
+	}
+
+	duration := time.Hour
+	if raw := params.Get("duration"); raw != "" {
+		d, err := parseDuration(raw)
+		if err != nil || d <= 0 {
+			return query, "", fmt.Errorf("invalid duration %q", raw)
+		}
+		duration = d
+	}
+	from, to, err := parseTimeRange(params.Get("from"), params.Get("to"), duration)
+	if err != nil {
+		return query, "", err
+	}
+	query.Start, query.End = from, to
+
+	if raw := params.Get("window"); raw != "" && raw != "auto" {
+		window, err := parseDuration(raw)
+		if err != nil {
+			return query, "", fmt.Errorf("invalid window %q", raw)
+		}
+		query.Window = window
+	}
+	if raw := params.Get("points"); raw != "" {
+		points, err := strconv.Atoi(raw)
+		if err != nil || points < 1 {
+			return query, "", fmt.Errorf("invalid points %q", raw)
+		}
+		query.MaxPoints = points
+	}
+
+	// Any other parameter is a tag filter; storage rejects unknown tags
+	for key := range params {
+		if slices.Contains(historyParams, key) || slices.Contains(reserved, key) {
+			continue
+		}
+		if query.Tags == nil {
+			query.Tags = make(map[string][]string)
+		}
+		query.Tags[key] = queryList(params, key)
+	}
+
+	return query, format, nil
+}
+
+// historyRows flattens a result into one record per point, the original
+// response format of the history endpoint
+func historyRows(history *storage.HistoryResult) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0)
+	for _, series := range history.Series {
+		for i, ms := range series.Times {
+			record := map[string]interface{}{
+				"time":         time.UnixMilli(ms).UTC(),
+				"_field":       series.Field,
+				"_value":       series.Values[i],
+				"_measurement": history.Measurement,
+				"agent_id":     history.AgentID,
+			}
+			for tag, value := range series.Tags {
+				record[tag] = value
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// Limits on user-supplied metadata
+const (
+	maxNameLength  = 128
+	maxNotesLength = 4096
+	maxLabelLength = 64
+	maxLabels      = 32
+)
+
+// metadataRequest is the body of PUT and PATCH /api/agents/{id}. Fields
+// left out of a PATCH keep their value; PUT clears them, except for the
+// connection settings which only change when given.
+type metadataRequest struct {
+	DisplayName *string   `json:"display_name"`
+	Tags        *[]string `json:"tags"`
+	Groups      *[]string `json:"groups"`
+	Location    *string   `json:"location"`
+	Notes       *string   `json:"notes"`
+	// An empty object clears the overrides
+	Thresholds *storage.Thresholds `json:"thresholds"`
+	Polling    *storage.Polling    `json:"polling"`
+	// Connection settings: tls switches to https, an empty auth_token
+	// stops sending one
+	TLS       *bool   `json:"tls"`
+	AuthToken *string `json:"auth_token"`
+}
+
+// validate normalizes the request and reports the first invalid field
+func (req *metadataRequest) validate() error {
+	for _, field := range []struct {
+		name  string
+		value *string
+		max   int
+	}{
+		{"display_name", req.DisplayName, maxNameLength},
+		{"location", req.Location, maxNameLength},
+		{"notes", req.Notes, maxNotesLength},
+	} {
+		if field.value == nil {
+			continue
+		}
+		*field.value = strings.TrimSpace(*field.value)
+		if len(*field.value) > field.max {
+			return fmt.Errorf("%s is longer than %d characters", field.name, field.max)
+		}
+	}
+
+	for _, field := range []struct {
+		name   string
+		labels *[]string
+	}{
+		{"tags", req.Tags},
+		{"groups", req.Groups},
+	} {
+		if field.labels == nil {
+			continue
+		}
+		labels, err := normalizeLabels(field.name, *field.labels)
+		if err != nil {
+			return err
+		}
+		*field.labels = labels
+	}
+
+	if req.Thresholds != nil {
+		if err := req.Thresholds.Validate(); err != nil {
+			return err
+		}
+	}
+	if req.Polling != nil {
+		if err := req.Polling.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply writes the request onto meta; replace clears omitted fields
+func (req *metadataRequest) apply(meta *storage.AgentMetadata, replace bool) {
+	if replace {
+		*meta = storage.AgentMetadata{}
+	}
+	if req.DisplayName != nil {
+		meta.DisplayName = *req.DisplayName
+	}
+	if req.Tags != nil {
+		meta.Tags = *req.Tags
+	}
+	if req.Groups != nil {
+		meta.Groups = *req.Groups
+	}
+	if req.Location != nil {
+		meta.Location = *req.Location
+	}
+	if req.Notes != nil {
+		meta.Notes = *req.Notes
+	}
+	if req.Thresholds != nil {
+		meta.Thresholds = req.Thresholds
+		if *req.Thresholds == (storage.Thresholds{}) {
+			meta.Thresholds = nil
+		}
+	}
+	if req.Polling != nil {
+		meta.Polling = req.Polling
+		if *req.Polling == (storage.Polling{}) {
+			meta.Polling = nil
+		}
+	}
+}
+
+// applyConnection writes the connection settings of the request onto conn
+func (req *metadataRequest) applyConnection(conn *storage.Connection) {
+	if req.TLS != nil {
+		conn.Scheme = storage.SchemeFor(*req.TLS)
+	}
+	if req.AuthToken != nil {
+		conn.AuthToken = strings.TrimSpace(*req.AuthToken)
+	}
+}
+
+// normalizeLabels trims tags or groups and drops case-insensitive duplicates.
+// Commas are rejected because filters take comma separated lists.
+func normalizeLabels(name string, labels []string) ([]string, error) {
+	if len(labels) > maxLabels {
+		return nil, fmt.Errorf("at most %d %s allowed", maxLabels, name)
+	}
+
+	normalized := make([]string, 0, len(labels))
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		switch {
+		case label == "":
+			return nil, fmt.Errorf("%s must not be empty", name)
+		case len(label) > maxLabelLength:
+			return nil, fmt.Errorf("%s entry %q is longer than %d characters", name, label, maxLabelLength)
+		case strings.Contains(label, ","):
+			return nil, fmt.Errorf("%s entry %q must not contain commas", name, label)
+		}
+
+		duplicate := false
+		for _, existing := range normalized {
+			if strings.EqualFold(existing, label) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			normalized = append(normalized, label)
+		}
+	}
+	return normalized, nil
+}
+
+// agentFilter selects agents by tag, group and status. Each parameter takes
+// a comma separated list (or repeats) and matches any of its values; all
+// given parameters must match.
+type agentFilter struct {
+	tags     []string
+	groups   []string
+	statuses []string
+}
+
+func parseAgentFilter(query url.Values) agentFilter {
+	return agentFilter{
+		tags:     queryList(query, "tag"),
+		groups:   queryList(query, "group"),
+		statuses: queryList(query, "status"),
+	}
+}
+
+func (f agentFilter) matches(agent *storage.Agent) bool {
+	if len(f.tags) > 0 && !anyMatch(f.tags, agent.HasTag) {
+		return false
+	}
+	if len(f.groups) > 0 && !anyMatch(f.groups, agent.InGroup) {
+		return false
+	}
+	if len(f.statuses) > 0 && !anyMatch(f.statuses, func(status string) bool {
+		return strings.EqualFold(agent.Status, status)
+	}) {
+		return false
+	}
+	return true
+}
+
+func anyMatch(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryList collects a repeated or comma separated query parameter
+func queryList(query url.Values, key string) []string {
+	var values []string
+	for _, raw := range query[key] {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,272 @@
+// Package batch queues items and sends them in batches from a background
+// goroutine, retrying failed batches with backoff. The metrics writers
+// share it so they behave the same when their endpoint is slow or down.
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrFull is returned by Add while the queue is full
+var ErrFull = errors.New("queue full")
+
+// Config controls batching, retries and what happens when the queue fills up
+type Config struct {
+	BatchSize     int           // Most items per batch
+	FlushInterval time.Duration // Send a partial batch after this long
+	// MaxPending bounds the queued items, including the batch being sent
+	MaxPending int
+	// MaxRetries for a batch whose failure is worth retrying
+	MaxRetries int
+	// The first retry waits MinBackoff, doubling up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// DropOldest makes room for new items by dropping the oldest ones
+	// waiting to be sent; otherwise Add refuses new items with ErrFull
+	DropOldest bool
+}
+
+// SendFunc makes one attempt at sending a batch. On failure it reports
+// whether the batch is worth retrying and how long the receiver asked to
+// wait, zero if it didn't.
+type SendFunc[T any] func(batch []T) (retry bool, wait time.Duration, err error)
+
+// FailFunc is called after every failed attempt, with giveUp set when the
+// batch is dropped and otherwise the delay before the next attempt
+type FailFunc[T any] func(batch []T, err error, giveUp bool, wait time.Duration)
+
+// Stats describes what a queue has sent
+type Stats struct {
+	Sent          uint64 // Items
+	SentBatches   uint64
+	FailedBatches uint64 // Given up after retries or rejected
+	Retries       uint64
+	Failed        uint64 // Items lost with failed batches
+	Overflowed    uint64 // Items dropped or refused because the queue was full
+	Pending       int
+	Retrying      bool // A failed batch is waiting for its next attempt
+	LastError     string
+	LastErrorAt   *time.Time
+	LastSentAt    *time.Time
+}
+
+// Queue batches items for a SendFunc. A batch leaves the queue only once it
+// is sent or given up, so while it is retried it still counts against
+// MaxPending and new items wait behind it.
+type Queue[T any] struct {
+	config Config
+	send   SendFunc[T]
+	failed FailFunc[T]
+
+	pending  []T
+	inFlight int // Items at the head of pending being sent
+	stats    Stats
+	mu       sync.Mutex
+	flush    chan struct{}
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// New returns a queue sending through send; failed may be nil
+func New[T any](config Config, send SendFunc[T], failed FailFunc[T]) *Queue[T] {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.MaxPending < config.BatchSize {
+		config.MaxPending = 20 * config.BatchSize
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = 30 * time.Second
+	}
+
+	return &Queue[T]{
+		config:   config,
+		send:     send,
+		failed:   failed,
+		flush:    make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Config returns the configuration with defaults filled in
+func (q *Queue[T]) Config() Config {
+	return q.config
+}
+
+func (q *Queue[T]) Start() {
+	go q.run()
+}
+
+// Stop sends what is still pending and returns once it is done. While
+// stopping each batch gets a single attempt: after the first failure the
+// rest of the queue is dropped, so a dead endpoint can't hold up shutdown.
+func (q *Queue[T]) Stop() {
+	close(q.stopChan)
+	<-q.done
+}
+
+// Add queues items. When the queue is full it either drops the oldest
+// waiting items or refuses all of the new ones with ErrFull.
+func (q *Queue[T]) Add(items ...T) error {
+	q.mu.Lock()
+	if over := len(q.pending) + len(items) - q.config.MaxPending; over > 0 {
+		if !q.config.DropOldest {
+			q.stats.Overflowed += uint64(len(items))
+			q.mu.Unlock()
+			return ErrFull
+		}
+		// The batch being sent is never dropped from under the sender
+		drop := min(over, len(q.pending)-q.inFlight)
+		q.pending = append(q.pending[:q.inFlight], q.pending[q.inFlight+drop:]...)
+		q.stats.Overflowed += uint64(drop)
+		if over > drop {
+			q.stats.Overflowed += uint64(over - drop)
+			items = items[over-drop:]
+		}
+	}
+	q.pending = append(q.pending, items...)
+	full := len(q.pending) >= q.config.BatchSize
+	q.mu.Unlock()
+
+	if full {
+		select {
+		case q.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *Queue[T]) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Pending = len(q.pending)
+	return stats
+}
+
+func (q *Queue[T]) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.sendPending(false)
+		case <-q.flush:
+			q.sendPending(true)
+		case <-q.stopChan:
+			q.sendPending(false)
+			return
+		}
+	}
+}
+
+// sendPending sends the queue in batches; fullOnly leaves a partial batch
+// for the next flush
+func (q *Queue[T]) sendPending(fullOnly bool) {
+	for {
+		q.mu.Lock()
+		n := min(len(q.pending), q.config.BatchSize)
+		if n == 0 || (fullOnly && n < q.config.BatchSize) {
+			q.mu.Unlock()
+			return
+		}
+		batch := make([]T, n)
+		copy(batch, q.pending)
+		q.inFlight = n
+		q.mu.Unlock()
+
+		err := q.sendBatch(batch)
+
+		q.mu.Lock()
+		q.pending = q.pending[n:]
+		q.inFlight = 0
+		var rest []T
+		if err != nil && q.stopping() && len(q.pending) > 0 {
+			rest, q.pending = q.pending, nil
+			q.stats.FailedBatches++
+			q.stats.Failed += uint64(len(rest))
+		}
+		q.mu.Unlock()
+
+		if len(rest) > 0 {
+			if q.failed != nil {
+				q.failed(rest, fmt.Errorf("not sent before shutdown: %w", err), true, 0)
+			}
+			return
+		}
+	}
+}
+
+func (q *Queue[T]) stopping() bool {
+	select {
+	case <-q.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendBatch sends one batch, retrying recoverable failures with backoff,
+// and returns the last error if it gave up
+func (q *Queue[T]) sendBatch(batch []T) error {
+	backoff := q.config.MinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, wait, err := q.send(batch)
+
+		now := time.Now()
+		if err == nil {
+			q.mu.Lock()
+			q.stats.Sent += uint64(len(batch))
+			q.stats.SentBatches++
+			q.stats.LastSentAt = &now
+			q.stats.Retrying = false
+			q.mu.Unlock()
+			return nil
+		}
+
+		giveUp := !retry || attempt >= q.config.MaxRetries || q.stopping()
+		wait = max(wait, backoff)
+
+		q.mu.Lock()
+		q.stats.LastError = err.Error()
+		q.stats.LastErrorAt = &now
+		q.stats.Retrying = !giveUp
+		if giveUp {
+			q.stats.FailedBatches++
+			q.stats.Failed += uint64(len(batch))
+		} else {
+			q.stats.Retries++
+		}
+		q.mu.Unlock()
+
+		if q.failed != nil {
+			q.failed(batch, err, giveUp, wait)
+		}
+		if giveUp {
+			return err
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-q.stopChan:
+			// Shutting down: one last attempt without waiting
+		}
+		backoff = min(backoff*2, q.config.MaxBackoff)
+	}
+}
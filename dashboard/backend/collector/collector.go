@@ -1,52 +1,127 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"net/http"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+	"github.com/AzertoxHDW/sentinel/internal/schema"
 )
 
 type MetricsCollector struct {
-	store      *storage.Store
-	influxDB   *storage.InfluxDB
+	store      storage.Store
+	metrics    storage.MetricsStore
+	sinks      []storage.MetricsWriter
 	httpClient *http.Client
-	interval   time.Duration
+	config     Config
+	stats      *pollStats
+	queue      chan pollJob
 	stopChan   chan struct{}
+
+	// Next poll of every scheduled agent
+	schedule map[string]*scheduleEntry
+	mu       sync.Mutex
+}
+
+// Config controls polling
+type Config struct {
+	Interval time.Duration
+	// Thresholds apply to agents and groups that don't set their own
+	Thresholds storage.Thresholds
+	// Workers bounds how many agents are polled at once
+	Workers int
+	// Timeout is the deadline for polling one agent
+	Timeout time.Duration
+	// Jitter spreads the first polls over this much time so agents aren't
+	// all hit at once; every agent keeps a stable offset
+	Jitter time.Duration
+	// MaxBackoff caps the delay between polls of an offline agent
+	MaxBackoff time.Duration
+	// MaxClockSkew is how far an agent's sample timestamp may be off before
+	// the dashboard's own time is stored instead
+	MaxClockSkew time.Duration
+	// Transport for agent requests, e.g. trusting the agents' CA; nil uses
+	// the default
+	Transport http.RoundTripper
+}
+
+// scheduleEntry tracks when an agent is due
+type scheduleEntry struct {
+	next       time.Time
+	inFlight   bool
+	backingOff bool
+}
+
+// pollJob is one due poll handed to the workers
+type pollJob struct {
+	agent      *storage.Agent
+	scheduled  time.Time
+	polling    storage.Polling
+	thresholds storage.Thresholds
 }
 
-func NewMetricsCollector(store *storage.Store, influxDB *storage.InfluxDB, interval time.Duration) *MetricsCollector {
+// scheduleTick is how often the scheduler looks for due agents
+const scheduleTick = 500 * time.Millisecond
+
+func NewMetricsCollector(store storage.Store, metrics storage.MetricsStore, config Config) *MetricsCollector {
+	if config.Workers <= 0 {
+		config.Workers = 16
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.Jitter < 0 || config.Jitter >= config.Interval {
+		config.Jitter = config.Interval / 2
+	}
+	if config.MaxBackoff < config.Interval {
+		config.MaxBackoff = config.Interval
+	}
+	if config.MaxClockSkew <= 0 {
+		config.MaxClockSkew = 5 * time.Second
+	}
+
 	return &MetricsCollector{
-		store:    store,
-		influxDB: influxDB,
-		interval: interval,
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-		},
-		stopChan: make(chan struct{}),
+		store:   store,
+		metrics: metrics,
+		config:  config,
+		// Deadlines are per agent, set on each request's context
+		httpClient: &http.Client{Transport: config.Transport},
+		stats:      &pollStats{},
+		queue:      make(chan pollJob, config.Workers),
+		stopChan:   make(chan struct{}),
+		schedule:   make(map[string]*scheduleEntry),
 	}
 }
 
 func (mc *MetricsCollector) Start() {
-	ticker := time.NewTicker(mc.interval)
+	for i := 0; i < mc.config.Workers; i++ {
+		go mc.worker()
+	}
+
+	ticker := time.NewTicker(scheduleTick)
 	go func() {
-		// Collect immediately on start
-		mc.collectAll()
-		
+		mc.dispatchDue(time.Now())
+
 		for {
 			select {
-			case <-ticker.C:
-				mc.collectAll()
+			case now := <-ticker.C:
+				mc.dispatchDue(now)
 			case <-mc.stopChan:
 				ticker.Stop()
 				return
 			}
 		}
 	}()
-	log.Printf("Metrics collector started (interval: %v)", mc.interval)
+	log.Printf("Metrics collector started (interval: %v, workers: %d, timeout: %v, jitter: %v, max backoff: %v)",
+		mc.config.Interval, mc.config.Workers, mc.config.Timeout, mc.config.Jitter, mc.config.MaxBackoff)
 }
 
 func (mc *MetricsCollector) Stop() {
@@ -54,82 +129,343 @@ func (mc *MetricsCollector) Stop() {
 	log.Println("Metrics collector stopped")
 }
 
-func (mc *MetricsCollector) collectAll() {
+// AddSink sends every sample to another writer besides the metrics store,
+// e.g. Prometheus remote write; call it before Start
+func (mc *MetricsCollector) AddSink(sink storage.MetricsWriter) {
+	mc.sinks = append(mc.sinks, sink)
+}
+
+// Stats returns scheduler metrics
+func (mc *MetricsCollector) Stats() PollStats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	stats := mc.stats.snapshot()
+	stats.Scheduled = len(mc.schedule)
+	for _, entry := range mc.schedule {
+		if entry.inFlight {
+			stats.InFlight++
+		}
+		if entry.backingOff {
+			stats.BackingOff++
+		}
+	}
+	return stats
+}
+
+// defaultPolling is the polling configuration of agents without overrides
+func (mc *MetricsCollector) defaultPolling() storage.Polling {
+	return storage.Polling{
+		IntervalMS:   int(mc.config.Interval.Milliseconds()),
+		TimeoutMS:    int(mc.config.Timeout.Milliseconds()),
+		MaxBackoffMS: int(mc.config.MaxBackoff.Milliseconds()),
+	}
+}
+
+// dispatchDue hands every agent whose poll is due to the workers. Agents
+// keep their own schedule; one that is still being polled is never queued
+// twice. When all workers are busy the rest wait for the next tick.
+func (mc *MetricsCollector) dispatchDue(now time.Time) {
 	agents := mc.store.GetAllAgents()
-	
+	groups := storage.GroupIndex(mc.store.GetAllGroups())
+	defaults := mc.defaultPolling()
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	active := make(map[string]bool, len(agents))
 	for _, agent := range agents {
-		if err := mc.collectAgent(agent); err != nil {
-			log.Printf("Failed to collect metrics for %s: %v", agent.ID, err)
+		if agent.Status == storage.StatusPaused {
+			continue
+		}
+		active[agent.ID] = true
+
+		polling := storage.ResolvePolling(agent, groups, defaults)
+		entry, ok := mc.schedule[agent.ID]
+		if !ok {
+			entry = &scheduleEntry{next: mc.firstPoll(agent, polling, now)}
+			mc.schedule[agent.ID] = entry
+		}
+		if entry.inFlight || now.Before(entry.next) {
+			continue
+		}
+
+		job := pollJob{
+			agent:      agent,
+			scheduled:  entry.next,
+			polling:    polling,
+			thresholds: storage.ResolveThresholds(agent, groups, mc.config.Thresholds),
 		}
+		select {
+		case mc.queue <- job:
+			entry.inFlight = true
+			mc.stats.dispatched(now.Sub(entry.next))
+		default:
+			return
+		}
+	}
+
+	// Removed and paused agents start over when they come back
+	for id, entry := range mc.schedule {
+		if !active[id] && !entry.inFlight {
+			delete(mc.schedule, id)
+		}
+	}
+}
+
+// firstPoll picks when a newly scheduled agent is due. A poll planned
+// before a restart is kept, so backoff survives it; otherwise agents are
+// spread over the jitter window.
+func (mc *MetricsCollector) firstPoll(agent *storage.Agent, polling storage.Polling, now time.Time) time.Time {
+	if agent.NextPoll != nil && agent.NextPoll.After(now) {
+		if limit := now.Add(polling.MaxBackoff()); agent.NextPoll.After(limit) {
+			return limit
+		}
+		return *agent.NextPoll
+	}
+	return now.Add(jitterOffset(agent.ID, min(mc.config.Jitter, polling.Interval())))
+}
+
+func (mc *MetricsCollector) worker() {
+	for {
+		select {
+		case job := <-mc.queue:
+			next, backingOff := mc.pollAgent(job)
+			mc.mu.Lock()
+			if entry, ok := mc.schedule[job.agent.ID]; ok {
+				entry.next = next
+				entry.inFlight = false
+				entry.backingOff = backingOff
+			}
+			mc.mu.Unlock()
+		case <-mc.stopChan:
+			return
+		}
+	}
+}
+
+// pollAgent collects one agent, advances its status and returns when it is
+// due again and whether it is backing off
+func (mc *MetricsCollector) pollAgent(job pollJob) (time.Time, bool) {
+	agent := job.agent
+	ctx, cancel := context.WithTimeout(context.Background(), job.polling.Timeout())
+	defer cancel()
+
+	result, err := mc.collectAgent(ctx, agent)
+	if err != nil {
+		log.Printf("Failed to collect metrics for %s: %v", agent.ID, err)
+	}
+
+	update := nextStatus(agent, result, job.thresholds)
+	update.ClockSkew = result.clockSkew
+	update.SchemaVersion = result.schemaVersion
+	update.SchemaWarning = result.schemaWarning
+	if result.schemaWarning != "" && result.schemaWarning != agent.SchemaWarning {
+		log.Printf("WARNING: agent %s payload: %s", agent.ID, result.schemaWarning)
 	}
+
+	// Stay on the agent's cadence; a poll that is already late runs now
+	now := time.Now()
+	delay := pollDelay(update.Failures, job.thresholds, job.polling)
+	update.NextPoll = job.scheduled.Add(delay)
+	if update.NextPoll.Before(now) {
+		update.NextPoll = now
+		mc.stats.overrun()
+	}
+	backingOff := delay > job.polling.Interval()
+	if backingOff {
+		log.Printf("Agent %s missed %d polls, next poll in %v", agent.ID, update.Failures, delay)
+	}
+
+	if update.Status != agent.Status {
+		log.Printf("Agent %s is now %s (%s)", agent.ID, update.Status, update.Reason)
+	}
+	if err := mc.store.UpdateAgentStatus(agent.ID, update); err != nil {
+		log.Printf("Failed to update status of %s: %v", agent.ID, err)
+	}
+	mc.stats.polled(now, result)
+	return update.NextPoll, backingOff
+}
+
+// jitterOffset spreads agents over [0, jitter) by hashing their ID, so an
+// agent is always polled at the same offset
+func jitterOffset(id string, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return time.Duration(h.Sum64() % uint64(jitter))
 }
 
-func (mc *MetricsCollector) collectAgent(agent *storage.Agent) error {
+// collectAgent polls one agent and stores its metrics
+func (mc *MetricsCollector) collectAgent(ctx context.Context, agent *storage.Agent) (pollResult, error) {
+	start := time.Now()
+	fail := func(reason string, err error) (pollResult, error) {
+		return pollResult{failed: true, reason: reason, duration: time.Since(start)}, err
+	}
+
 	// Fetch metrics from agent
-	metricsURL := fmt.Sprintf("http://%s:%d/metrics", agent.IPAddress, agent.Port)
-	resp, err := mc.httpClient.Get(metricsURL)
+	resp, err := mc.get(ctx, agent, "/metrics")
 	if err != nil {
-		mc.store.UpdateAgentStatus(agent.ID, "offline")
-		return err
+		return fail(FailureReason(err), err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return fail(storage.ReasonHTTPError, fmt.Errorf("unexpected status %s", resp.Status))
+	}
+
 	// Parse metrics from agent
-	var agentMetrics AgentMetrics
+	var agentMetrics schema.SystemMetrics
 	if err := json.NewDecoder(resp.Body).Decode(&agentMetrics); err != nil {
-		return err
+		return fail(storage.ReasonBadPayload, fmt.Errorf("bad payload: %w", err))
 	}
+	received := time.Now()
+	result := pollResult{duration: received.Sub(start)}
 
-	// Update agent status
-	mc.store.UpdateAgentStatus(agent.ID, "online")
+	// Newer payloads are read as far as this build understands them
+	err = schema.Upgrade(&agentMetrics)
+	result.schemaVersion = agentMetrics.SchemaVersion
+	if err != nil {
+		result.schemaWarning = err.Error()
+	}
+	if errors.Is(err, schema.ErrTooOld) {
+		result.failed = true
+		result.reason = storage.ReasonBadPayload
+		return result, err
+	}
 
 	// Convert to storage format
 	metrics := convertToStorageMetrics(&agentMetrics)
 
+	// Keep the host's sample time unless its clock can't be trusted
+	if agentMetrics.Timestamp.IsZero() {
+		metrics.Timestamp = start
+	} else {
+		result.clockSkew = clockSkew(agentMetrics.Timestamp, start, received)
+		if result.clockSkew.Abs() > mc.config.MaxClockSkew {
+			if (time.Duration(agent.ClockSkewMS) * time.Millisecond).Abs() <= mc.config.MaxClockSkew {
+				log.Printf("WARNING: clock of agent %s is off by %v; storing dashboard time instead", agent.ID, result.clockSkew.Round(time.Millisecond))
+			}
+			metrics.Timestamp = start
+		}
+	}
+
 	// Use the actual hostname from metrics for consistency
 	hostname := agentMetrics.Hostname
-	
+
 	log.Printf("Writing metrics for agent_id=%s, hostname=%s", agent.ID, hostname)
 
-	// Write to InfluxDB - use agent.ID consistently
-	return mc.influxDB.WriteMetrics(agent.ID, metrics)
-}
-
-// AgentMetrics matches the structure from the agent's /metrics endpoint
-type AgentMetrics struct {
-	Hostname string `json:"hostname"`
-	CPU      struct {
-		UsagePercent float64 `json:"usage_percent"`
-		CoreCount    int     `json:"core_count"`
-	} `json:"cpu"`
-	Memory struct {
-		Total       uint64  `json:"total"`
-		Used        uint64  `json:"used"`
-		Available   uint64  `json:"available"`
-		UsedPercent float64 `json:"used_percent"`
-	} `json:"memory"`
-	Disk []struct {
-		Device      string  `json:"device"`
-		MountPoint  string  `json:"mount_point"`
-		Total       uint64  `json:"total"`
-		Used        uint64  `json:"used"`
-		Free        uint64  `json:"free"`
-		UsedPercent float64 `json:"used_percent"`
-	} `json:"disk"`
-	Network []struct {
-		Interface   string `json:"interface"`
-		BytesSent   uint64 `json:"bytes_sent"`
-		BytesRecv   uint64 `json:"bytes_recv"`
-		PacketsSent uint64 `json:"packets_sent"`
-		PacketsRecv uint64 `json:"packets_recv"`
-	} `json:"network"`
-}
-
-func convertToStorageMetrics(am *AgentMetrics) *storage.SystemMetrics {
+	// Agent self-telemetry is best effort; older agents don't expose it
+	health, err := mc.collectAgentHealth(ctx, agent)
+	if err != nil {
+		log.Printf("Failed to fetch agent stats for %s: %v", agent.ID, err)
+	}
+	result.collectorErrors = health != nil && len(health.FailingCollectors) > 0
+
+	// Extra outputs must not fail the poll
+	for _, sink := range mc.sinks {
+		if err := sink.WriteMetrics(agent.ID, metrics); err != nil {
+			log.Printf("Failed to forward metrics for %s: %v", agent.ID, err)
+		}
+	}
+
+	// Write to the metrics store - use agent.ID consistently, since version 1
+	// payloads may carry no agent_id. A failed write doesn't fail the poll:
+	// the agent did answer.
+	if err := mc.metrics.WriteMetrics(agent.ID, metrics); err != nil {
+		return result, fmt.Errorf("storing metrics: %w", err)
+	}
+	return result, nil
+}
+
+// clockSkew is how far an agent's sample time lies outside the request
+// that fetched it. The sample is taken while the request is in flight, so
+// anything within [sent, received] counts as no skew.
+func clockSkew(sample, sent, received time.Time) time.Duration {
+	switch {
+	case sample.Before(sent):
+		return sample.Sub(sent)
+	case sample.After(received):
+		return sample.Sub(received)
+	}
+	return 0
+}
+
+func (mc *MetricsCollector) collectAgentHealth(ctx context.Context, agent *storage.Agent) (*storage.AgentHealth, error) {
+	resp, err := mc.get(ctx, agent, "/agent/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var stats AgentStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	health := &storage.AgentHealth{
+		Status:        stats.Status,
+		Version:       stats.Build.Version,
+		UptimeSeconds: stats.UptimeSeconds,
+		Goroutines:    stats.Runtime.Goroutines,
+		RSSBytes:      stats.Runtime.RSSBytes,
+		CheckedAt:     time.Now(),
+	}
+	for name, c := range stats.Collectors {
+		health.CollectorErrors += c.Errors
+		if c.LastFailed {
+			health.FailingCollectors = append(health.FailingCollectors, name)
+		}
+	}
+	sort.Strings(health.FailingCollectors)
+
+	return health, mc.store.UpdateAgentHealth(agent.ID, health)
+}
+
+func (mc *MetricsCollector) get(ctx context.Context, agent *storage.Agent, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agent.BaseURL()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	agent.Authorize(req)
+	return mc.httpClient.Do(req)
+}
+
+// AgentStats matches the structure from the agent's /agent/stats endpoint
+type AgentStats struct {
+	Status string `json:"status"`
+	Build  struct {
+		Version string `json:"version"`
+	} `json:"build"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Collectors    map[string]struct {
+		Errors     uint64 `json:"errors"`
+		LastFailed bool   `json:"last_failed"`
+	} `json:"collectors"`
+	Runtime struct {
+		Goroutines int    `json:"goroutines"`
+		RSSBytes   uint64 `json:"rss_bytes"`
+	} `json:"runtime"`
+}
+
+func convertToStorageMetrics(am *schema.SystemMetrics) *storage.SystemMetrics {
 	metrics := &storage.SystemMetrics{
+		Timestamp:    am.Timestamp,
 		Hostname:     am.Hostname,
+		Uptime:       am.Uptime,
 		CPUPercent:   am.CPU.UsagePercent,
 		CoreCount:    am.CPU.CoreCount,
+		CPUModel:     am.CPU.Model,
+		LoadAvg:      am.CPU.LoadAvg,
 		MemTotal:     am.Memory.Total,
 		MemUsed:      am.Memory.Used,
 		MemAvailable: am.Memory.Available,
@@ -142,6 +478,7 @@ func convertToStorageMetrics(am *AgentMetrics) *storage.SystemMetrics {
 		metrics.Disks = append(metrics.Disks, storage.DiskMetric{
 			Device:      disk.Device,
 			MountPoint:  disk.MountPoint,
+			FSType:      disk.FSType,
 			Total:       disk.Total,
 			Used:        disk.Used,
 			Free:        disk.Free,
@@ -160,4 +497,4 @@ func convertToStorageMetrics(am *AgentMetrics) *storage.SystemMetrics {
 	}
 
 	return metrics
-}
\ No newline at end of file
+}
@@ -0,0 +1,24 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// FailureReason classifies a failed request to an agent for the status history
+func FailureReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return storage.ReasonConnectionRefused
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return storage.ReasonTimeout
+	default:
+		return storage.ReasonUnreachable
+	}
+}
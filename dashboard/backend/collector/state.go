@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// pollResult is what one poll of an agent observed
+type pollResult struct {
+	failed          bool
+	reason          string // Failure reason when failed
+	duration        time.Duration
+	collectorErrors bool          // Agent reports failing collectors
+	clockSkew       time.Duration // Agent clock minus dashboard clock
+	schemaVersion   int           // Payload version, 0 when nothing was decoded
+	schemaWarning   string        // Set when the version is outside the supported range
+}
+
+// nextStatus runs the status state machine. A single failed poll doesn't
+// change the status; the agent turns stale, then offline, only after
+// enough consecutive failures. Any answer resets the count.
+func nextStatus(agent *storage.Agent, result pollResult, thresholds storage.Thresholds) storage.StatusUpdate {
+	if result.failed {
+		failures := agent.Failures + 1
+		status := agent.Status
+		switch {
+		case failures >= thresholds.OfflineAfter:
+			status = storage.StatusOffline
+		case failures >= thresholds.StaleAfter:
+			status = storage.StatusStale
+		case status == "":
+			status = storage.StatusUnknown
+		}
+		return storage.StatusUpdate{Status: status, Reason: result.reason, Failures: failures}
+	}
+
+	update := storage.StatusUpdate{Status: storage.StatusOnline, Reason: storage.ReasonResponded, Seen: true}
+	switch {
+	case thresholds.SlowResponseMS > 0 && result.duration > time.Duration(thresholds.SlowResponseMS)*time.Millisecond:
+		update.Status = storage.StatusDegraded
+		update.Reason = storage.ReasonSlow
+	case result.collectorErrors:
+		update.Status = storage.StatusDegraded
+		update.Reason = storage.ReasonCollectorErrors
+	}
+	return update
+}
+
+// pollDelay is the time until an agent's next poll. Offline agents back off
+// exponentially, doubling with every further miss up to the polling cap, so
+// a machine that is switched off isn't hammered at the normal interval.
+func pollDelay(failures int, thresholds storage.Thresholds, polling storage.Polling) time.Duration {
+	delay := polling.Interval()
+	if failures < thresholds.OfflineAfter {
+		return delay
+	}
+	for i := thresholds.OfflineAfter; i <= failures && delay < polling.MaxBackoff(); i++ {
+		delay *= 2
+	}
+	return min(delay, polling.MaxBackoff())
+}
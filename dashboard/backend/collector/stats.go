@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// PollStats describes the collector's scheduler
+type PollStats struct {
+	Scheduled     int           `json:"scheduled"`   // Agents with a pending poll
+	InFlight      int           `json:"in_flight"`   // Polls running right now
+	BackingOff    int           `json:"backing_off"` // Offline agents polled less often
+	TotalPolls    uint64        `json:"total_polls"`
+	TotalFailures uint64        `json:"total_failures"`
+	Overruns      uint64        `json:"overruns"` // Polls that finished after the next one was due
+	LastPoll      *time.Time    `json:"last_poll,omitempty"`
+	LastDuration  time.Duration `json:"last_duration_ns"`
+	MaxDuration   time.Duration `json:"max_duration_ns"`
+	MaxLag        time.Duration `json:"max_lag_ns"` // Longest a due poll waited for a worker
+}
+
+type pollStats struct {
+	stats PollStats
+	mu    sync.Mutex
+}
+
+// dispatched records a poll handed to a worker lag after it was due
+func (p *pollStats) dispatched(lag time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lag > p.stats.MaxLag {
+		p.stats.MaxLag = lag
+	}
+}
+
+// polled records a finished poll
+func (p *pollStats) polled(at time.Time, result pollResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stats.TotalPolls++
+	if result.failed {
+		p.stats.TotalFailures++
+	}
+	p.stats.LastPoll = &at
+	p.stats.LastDuration = result.duration
+	if result.duration > p.stats.MaxDuration {
+		p.stats.MaxDuration = result.duration
+	}
+}
+
+func (p *pollStats) overrun() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Overruns++
+}
+
+func (p *pollStats) snapshot() PollStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
@@ -0,0 +1,193 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSDConfig points unicast DNS-SD at a DNS server
+type DNSSDConfig struct {
+	Domain   string // Zone holding the _sentinel._tcp records, e.g. "example.com"
+	Resolver string // host:port of the DNS server; empty uses /etc/resolv.conf
+}
+
+// DNSSD browses for agents with unicast DNS-SD: PTR records list the
+// instances, SRV gives their host and port, TXT the same metadata as mDNS
+type DNSSD struct {
+	service  string
+	resolver string
+}
+
+func NewDNSSD(config DNSSDConfig) (*DNSSD, error) {
+	if config.Domain == "" {
+		return nil, fmt.Errorf("DNS-SD needs a domain")
+	}
+
+	resolver := config.Resolver
+	if resolver == "" {
+		clientConfig, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, fmt.Errorf("no DNS-SD resolver configured: %w", err)
+		}
+		if len(clientConfig.Servers) == 0 {
+			return nil, fmt.Errorf("no DNS-SD resolver configured and none in /etc/resolv.conf")
+		}
+		resolver = net.JoinHostPort(clientConfig.Servers[0], clientConfig.Port)
+	} else if _, _, err := net.SplitHostPort(resolver); err != nil {
+		// Port is optional
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+
+	return &DNSSD{
+		service:  dns.Fqdn(ServiceType + "." + strings.TrimSuffix(config.Domain, ".")),
+		resolver: resolver,
+	}, nil
+}
+
+// Scan resolves every advertised instance; timeout applies to each query
+func (d *DNSSD) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
+	client := &dns.Client{Timeout: timeout}
+
+	ptr, err := d.query(client, d.service, dns.TypePTR)
+	if err != nil {
+		return nil, fmt.Errorf("DNS-SD browse of %s failed: %w", d.service, err)
+	}
+
+	agents := make([]*DiscoveredAgent, 0)
+	for _, rr := range ptr.Answer {
+		record, ok := rr.(*dns.PTR)
+		if !ok {
+			continue
+		}
+
+		agent, err := d.resolve(client, record.Ptr)
+		if err != nil {
+			log.Printf("DNS-SD: failed to resolve %s: %v", record.Ptr, err)
+			continue
+		}
+		if len(agent.IPs) > 0 {
+			agents = append(agents, agent)
+			log.Printf("Discovered agent: %s at %v:%d (DNS-SD)", agent.Instance, agent.IPs, agent.Port)
+		}
+	}
+
+	return agents, nil
+}
+
+// resolve looks up the SRV, TXT and address records of one instance
+func (d *DNSSD) resolve(client *dns.Client, instance string) (*DiscoveredAgent, error) {
+	srv, err := d.query(client, instance, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	agent := &DiscoveredAgent{
+		Instance: instanceName(instance, d.service),
+		IPs:      make([]string, 0),
+		Sources:  []string{SourceDNSSD},
+	}
+	for _, rr := range srv.Answer {
+		if record, ok := rr.(*dns.SRV); ok {
+			agent.Hostname = record.Target
+			agent.Port = int(record.Port)
+			break
+		}
+	}
+	if agent.Hostname == "" {
+		return nil, fmt.Errorf("no SRV record")
+	}
+
+	if txt, err := d.query(client, instance, dns.TypeTXT); err == nil {
+		for _, rr := range txt.Answer {
+			if record, ok := rr.(*dns.TXT); ok {
+				parseTXT(agent, record.Txt)
+			}
+		}
+	}
+
+	// Servers often include the target addresses with the SRV answer
+	addresses := srv.Extra
+	if !hasAddress(addresses, agent.Hostname) {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			if reply, err := d.query(client, agent.Hostname, qtype); err == nil {
+				addresses = append(addresses, reply.Answer...)
+			}
+		}
+	}
+
+	// IPv4 first, like the mDNS scanner
+	for _, rr := range addresses {
+		if record, ok := rr.(*dns.A); ok && strings.EqualFold(record.Hdr.Name, agent.Hostname) {
+			agent.IPs = append(agent.IPs, record.A.String())
+		}
+	}
+	for _, rr := range addresses {
+		if record, ok := rr.(*dns.AAAA); ok && strings.EqualFold(record.Hdr.Name, agent.Hostname) && !record.AAAA.IsLinkLocalUnicast() {
+			agent.IPs = append(agent.IPs, record.AAAA.String())
+		}
+	}
+
+	return agent, nil
+}
+
+// query sends one question, retrying over TCP when the answer is truncated
+func (d *DNSSD) query(client *dns.Client, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+
+	reply, _, err := client.Exchange(msg, d.resolver)
+	if err == nil && reply.Truncated {
+		tcp := &dns.Client{Net: "tcp", Timeout: client.Timeout}
+		reply, _, err = tcp.Exchange(msg, d.resolver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s", dns.RcodeToString[reply.Rcode])
+	}
+	return reply, nil
+}
+
+func hasAddress(records []dns.RR, name string) bool {
+	for _, rr := range records {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+			if strings.EqualFold(rr.Header().Name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// instanceName extracts the human readable instance from its DNS-SD name,
+// e.g. `web\ 01._sentinel._tcp.example.com.` -> "web 01"
+func instanceName(fqdn, service string) string {
+	label := strings.TrimSuffix(fqdn, "."+service)
+
+	var b strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			b.WriteByte(label[i])
+			continue
+		}
+		// \DDD is a decimal byte, anything else a literal character
+		if i+3 < len(label) {
+			if n, err := strconv.Atoi(label[i+1 : i+4]); err == nil && n < 256 {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		i++
+		b.WriteByte(label[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"strings"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// MatchKind tells how a discovered agent was linked to a registered one
+type MatchKind string
+
+const (
+	MatchNone     MatchKind = ""
+	MatchID       MatchKind = "id"       // Stable agent ID advertised in TXT records
+	MatchHostname MatchKind = "hostname" // Normalized hostname or instance name
+	MatchAddress  MatchKind = "address"  // Same IP and port
+)
+
+// FindRegistered returns the registered agent a discovered agent belongs to,
+// if any, and how it was matched
+func FindRegistered(disc *DiscoveredAgent, registered []*storage.Agent) (*storage.Agent, MatchKind) {
+	// Normalize discovered names for comparison
+	discInstanceNorm := normalizeHostname(disc.Instance)
+	discHostnameNorm := normalizeHostname(disc.Hostname)
+
+	// 0. Stable agent ID is authoritative
+	if disc.ID != "" {
+		for _, agent := range registered {
+			if agent.ID == disc.ID {
+				return agent, MatchID
+			}
+		}
+	}
+
+	for _, agent := range registered {
+		// Agents with UUIDs on both sides are told apart by them alone, so
+		// two hosts with the same short name stay separate
+		if disc.ID != "" && !agent.HasLegacyID() {
+			continue
+		}
+		regHostnameNorm := normalizeHostname(agent.Hostname)
+
+		// 1. Check if Hostname matches (Primary ID check with normalization)
+		// Checks if "myserver" == "myserver" OR "myserver" == "myserver.local"
+		if regHostnameNorm == discInstanceNorm || regHostnameNorm == discHostnameNorm {
+			return agent, MatchHostname
+		}
+	}
+
+	// 2. Check if any IP matches (Fallback)
+	for _, agent := range registered {
+		if agent.Port != disc.Port {
+			continue
+		}
+		for _, ip := range disc.IPs {
+			if agent.IPAddress == ip || contains(agent.Addresses, ip) {
+				return agent, MatchAddress
+			}
+		}
+	}
+
+	return nil, MatchNone
+}
+
+// Helper to normalize hostnames for comparison
+// Removes trailing dots, converts to lowercase, and removes domain suffixes
+// Example: "MyServer.local." -> "myserver"
+func normalizeHostname(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	h = strings.TrimSuffix(h, ".")
+	if idx := strings.Index(h, "."); idx != -1 {
+		return h[:idx]
+	}
+	return h
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
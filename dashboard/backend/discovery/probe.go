@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// Probe checks every address concurrently and returns the ones where an
+// agent answers on /health, keeping the input (preference) order. Agents on
+// multi-homed hosts often advertise Docker bridge or VPN addresses the
+// dashboard cannot reach. An empty scheme means http.
+func Probe(client *http.Client, scheme string, addresses []string, port int) []string {
+	reachable := make([]bool, len(addresses))
+
+	var wg sync.WaitGroup
+	for i, addr := range addresses {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+
+			resp, err := client.Get(storage.BaseURL(scheme, addr, port) + "/health")
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			reachable[i] = resp.StatusCode == http.StatusOK
+		}(i, addr)
+	}
+	wg.Wait()
+
+	var result []string
+	for i, addr := range addresses {
+		if reachable[i] {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// agentHealth is the subset of the agent's /health response used to
+// recognise it
+type agentHealth struct {
+	Status       string   `json:"status"`
+	AgentID      string   `json:"agent_id"`
+	Hostname     string   `json:"hostname"`
+	Version      string   `json:"version"`
+	OS           string   `json:"os"`
+	Arch         string   `json:"arch"`
+	TLS          bool     `json:"tls"`
+	AuthRequired bool     `json:"auth_required"`
+	Tags         []string `json:"tags"`
+}
+
+// Identify returns the agent answering /health at addr:port over http or
+// https, or nil. source is recorded in the result's Sources.
+func Identify(client *http.Client, addr string, port int, source string) *DiscoveredAgent {
+	for _, scheme := range []string{storage.SchemeHTTP, storage.SchemeHTTPS} {
+		if agent := identify(client, scheme, addr, port, source); agent != nil {
+			return agent
+		}
+	}
+	return nil
+}
+
+func identify(client *http.Client, scheme, addr string, port int, source string) *DiscoveredAgent {
+	resp, err := client.Get(storage.BaseURL(scheme, addr, port) + "/health")
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var health agentHealth
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil || health.Status == "" {
+		return nil
+	}
+
+	// Agents older than the extended health endpoint don't report a
+	// hostname; the address stands in until the agent is registered
+	hostname := health.Hostname
+	if hostname == "" {
+		hostname = addr
+	}
+
+	return &DiscoveredAgent{
+		ID:           health.AgentID,
+		Hostname:     hostname,
+		Instance:     hostname,
+		Port:         port,
+		IPs:          []string{addr},
+		Version:      health.Version,
+		OS:           health.OS,
+		Arch:         health.Arch,
+		TLS:          health.TLS || scheme == storage.SchemeHTTPS,
+		AuthRequired: health.AuthRequired,
+		Tags:         health.Tags,
+		Sources:      []string{source},
+	}
+}
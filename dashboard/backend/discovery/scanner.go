@@ -3,9 +3,13 @@ package discovery
 import (
 	"context"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grandcat/zeroconf"
+
+	"github.com/AzertoxHDW/sentinel/internal/netiface"
 )
 
 const (
@@ -13,21 +17,55 @@ const (
 	Domain      = "local."
 )
 
+// Discovery source names reported in DiscoveredAgent.Sources
+const (
+	SourceMDNS  = "mdns"
+	SourceSweep = "sweep"
+	SourceDNSSD = "dns-sd"
+	SourceSeed  = "seed"
+)
+
 type DiscoveredAgent struct {
-	Hostname string   `json:"hostname"`
-	Instance string   `json:"instance"`
-	Port     int      `json:"port"`
-	IPs      []string `json:"ips"`
+	ID           string   `json:"id,omitempty"` // Persistent agent UUID (empty for older agents)
+	Hostname     string   `json:"hostname"`
+	Instance     string   `json:"instance"`
+	Port         int      `json:"port"`
+	IPs          []string `json:"ips"`
+	Version      string   `json:"version,omitempty"`
+	OS           string   `json:"os,omitempty"`
+	Arch         string   `json:"arch,omitempty"`
+	TLS          bool     `json:"tls"`
+	AuthRequired bool     `json:"auth_required"`
+	Tags         []string `json:"tags,omitempty"`
+	Sources      []string `json:"sources,omitempty"` // How the agent was found (mdns, sweep, dns-sd, seed)
 }
 
-type Scanner struct{}
+// ScannerConfig restricts browsing to some interfaces (glob patterns)
+type ScannerConfig struct {
+	Interfaces        []string
+	ExcludeInterfaces []string
+}
 
-func NewScanner() *Scanner {
-	return &Scanner{}
+type Scanner struct {
+	config ScannerConfig
+}
+
+func NewScanner(config ScannerConfig) *Scanner {
+	return &Scanner{config: config}
 }
 
 func (s *Scanner) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
-	resolver, err := zeroconf.NewResolver(nil)
+	ifaces, err := netiface.Select(s.config.Interfaces, s.config.ExcludeInterfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []zeroconf.ClientOption
+	if ifaces != nil {
+		opts = append(opts, zeroconf.SelectIfaces(ifaces))
+	}
+
+	resolver, err := zeroconf.NewResolver(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -59,12 +97,21 @@ func (s *Scanner) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
 				Instance: entry.Instance,
 				Port:     entry.Port,
 				IPs:      make([]string, 0),
+				Sources:  []string{SourceMDNS},
 			}
+			parseTXT(agent, entry.Text)
 
-			// Collect IPv4 addresses
+			// Collect IPv4 then IPv6 addresses; link-local IPv6 is skipped
+			// because mDNS doesn't tell us which interface zone to use
 			for _, ip := range entry.AddrIPv4 {
 				agent.IPs = append(agent.IPs, ip.String())
 			}
+			for _, ip := range entry.AddrIPv6 {
+				if ip.IsLinkLocalUnicast() {
+					continue
+				}
+				agent.IPs = append(agent.IPs, ip.String())
+			}
 
 			if len(agent.IPs) > 0 {
 				agents = append(agents, agent)
@@ -76,4 +123,32 @@ func (s *Scanner) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
 			return agents, nil
 		}
 	}
-}
\ No newline at end of file
+}
+
+// parseTXT fills agent metadata from the TXT records advertised by the agent.
+// Unknown keys are ignored so newer agents stay compatible.
+func parseTXT(agent *DiscoveredAgent, records []string) {
+	for _, record := range records {
+		key, value, _ := strings.Cut(record, "=")
+		switch key {
+		case "id":
+			agent.ID = value
+		case "version":
+			agent.Version = value
+		case "os":
+			agent.OS = value
+		case "arch":
+			agent.Arch = value
+		case "tls":
+			agent.TLS, _ = strconv.ParseBool(value)
+		case "auth":
+			agent.AuthRequired, _ = strconv.ParseBool(value)
+		case "tags":
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					agent.Tags = append(agent.Tags, tag)
+				}
+			}
+		}
+	}
+}
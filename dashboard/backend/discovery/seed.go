@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Port used for seed entries that don't specify one
+const defaultAgentPort = 9100
+
+// Seeds discovers agents from a static file listing their addresses, one
+// "host[:port]" per line; blank lines and lines starting with # are
+// ignored. Hostnames are resolved on every scan and the file is re-read, so
+// edits apply without a restart.
+type Seeds struct {
+	file      string
+	transport http.RoundTripper
+}
+
+// NewSeeds reads seeds from file, probing them through transport (nil for
+// the default)
+func NewSeeds(file string, transport http.RoundTripper) (*Seeds, error) {
+	seeds := &Seeds{file: file, transport: transport}
+	// Fail early on a missing or malformed file
+	if _, err := seeds.load(); err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
+
+type seedEntry struct {
+	host string
+	port int
+}
+
+func (s *Seeds) load() ([]seedEntry, error) {
+	f, err := os.Open(s.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []seedEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		entry := seedEntry{host: text, port: defaultAgentPort}
+		if host, port, err := net.SplitHostPort(text); err == nil {
+			entry.host = host
+			entry.port, err = strconv.Atoi(port)
+			if err != nil || entry.port < 1 || entry.port > 65535 {
+				return nil, fmt.Errorf("%s:%d: invalid port %q", s.file, line, port)
+			}
+		}
+		// Bare IPv6 literals may be bracketed without a port
+		entry.host = strings.TrimSuffix(strings.TrimPrefix(entry.host, "["), "]")
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Scan probes every seed address; timeout bounds each lookup and probe
+func (s *Seeds) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
+	entries, err := s.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seed file: %w", err)
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: s.transport}
+
+	var (
+		agents []*DiscoveredAgent
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	for _, entry := range entries {
+		wg.Add(1)
+		go func(entry seedEntry) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			addrs, err := net.DefaultResolver.LookupHost(ctx, entry.host)
+			cancel()
+			if err != nil {
+				log.Printf("Seed %s: %v", entry.host, err)
+				return
+			}
+
+			for _, addr := range addrs {
+				agent := Identify(client, addr, entry.port, SourceSeed)
+				if agent == nil {
+					continue
+				}
+				log.Printf("Discovered agent: %s at %s:%d (seed)", agent.Instance, addr, entry.port)
+
+				mu.Lock()
+				agents = append(agents, agent)
+				mu.Unlock()
+			}
+		}(entry)
+	}
+	wg.Wait()
+
+	return merge(agents), nil
+}
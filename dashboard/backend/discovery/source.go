@@ -0,0 +1,123 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source finds agents on the network
+type Source interface {
+	Scan(timeout time.Duration) ([]*DiscoveredAgent, error)
+}
+
+// Sources runs several discovery sources concurrently and merges their
+// results, so an agent seen by both mDNS and a sweep is reported once
+type Sources []Source
+
+func (s Sources) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
+	results := make([][]*DiscoveredAgent, len(s))
+	errs := make([]error, len(s))
+
+	var wg sync.WaitGroup
+	for i, source := range s {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			results[i], errs[i] = source.Scan(timeout)
+		}(i, source)
+	}
+	wg.Wait()
+
+	// Only fail when no source worked at all
+	var failures []string
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("Discovery source failed: %v", err)
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(s) > 0 && len(failures) == len(s) {
+		return nil, fmt.Errorf("all discovery sources failed: %s", strings.Join(failures, "; "))
+	}
+
+	return merge(results...), nil
+}
+
+// merge combines agents seen by several sources. Agents are the same when
+// their IDs match; when either lacks an ID (older agents, DNS records
+// without one) they must share an address and port. Hostnames alone don't
+// count: distinct hosts often share a short name like "nas". Addresses and
+// sources are unioned; the first non-empty value wins for every other
+// field.
+func merge(lists ...[]*DiscoveredAgent) []*DiscoveredAgent {
+	var merged []*DiscoveredAgent
+
+	for _, list := range lists {
+		for _, agent := range list {
+			existing := findSame(merged, agent)
+			if existing == nil {
+				copied := *agent
+				copied.IPs = append([]string(nil), agent.IPs...)
+				copied.Sources = append([]string(nil), agent.Sources...)
+				merged = append(merged, &copied)
+				continue
+			}
+
+			if existing.ID == "" {
+				existing.ID = agent.ID
+			}
+			for _, ip := range agent.IPs {
+				if !contains(existing.IPs, ip) {
+					existing.IPs = append(existing.IPs, ip)
+				}
+			}
+			for _, source := range agent.Sources {
+				if !contains(existing.Sources, source) {
+					existing.Sources = append(existing.Sources, source)
+				}
+			}
+			if existing.Instance == "" {
+				existing.Instance = agent.Instance
+			}
+			if existing.Version == "" {
+				existing.Version = agent.Version
+			}
+			if existing.OS == "" {
+				existing.OS = agent.OS
+			}
+			if existing.Arch == "" {
+				existing.Arch = agent.Arch
+			}
+			if len(existing.Tags) == 0 {
+				existing.Tags = agent.Tags
+			}
+			existing.TLS = existing.TLS || agent.TLS
+			existing.AuthRequired = existing.AuthRequired || agent.AuthRequired
+		}
+	}
+
+	return merged
+}
+
+func findSame(agents []*DiscoveredAgent, agent *DiscoveredAgent) *DiscoveredAgent {
+	for _, other := range agents {
+		if agent.ID != "" && other.ID != "" {
+			if agent.ID == other.ID {
+				return other
+			}
+			continue
+		}
+		if other.Port != agent.Port {
+			continue
+		}
+		for _, ip := range agent.IPs {
+			if contains(other.IPs, ip) {
+				return other
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Largest number of addresses a sweep may cover, to catch typos like /8
+const maxSweepAddresses = 65536
+
+// Highest probe rate, far beyond what a network should take and low enough
+// that the pacing interval never rounds down to zero
+const maxSweepRate = 100000
+
+// SweepConfig describes the address ranges probed for agents
+type SweepConfig struct {
+	Subnets      []string      // CIDR ranges, IPv4 or IPv6
+	Ports        []int         // Ports tried on every address
+	Concurrency  int           // Probes in flight at once
+	Rate         int           // Probes started per second
+	ProbeTimeout time.Duration // Per-probe timeout
+	// Transport for probes, e.g. trusting the agents' CA; nil uses the default
+	Transport http.RoundTripper
+}
+
+// Sweeper finds agents by probing every address of the configured subnets.
+// It works where multicast doesn't: across VLANs and from Docker bridge
+// networks.
+type Sweeper struct {
+	config   SweepConfig
+	prefixes []netip.Prefix
+	client   *http.Client
+
+	mu      sync.Mutex
+	running chan struct{}      // Closed when the current pass ends; nil when idle
+	found   []*DiscoveredAgent // Agents of the last complete pass
+	current []*DiscoveredAgent // Agents found so far by the current pass
+}
+
+func NewSweeper(config SweepConfig) (*Sweeper, error) {
+	if len(config.Ports) == 0 {
+		return nil, fmt.Errorf("sweep needs at least one port")
+	}
+	for _, port := range config.Ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid sweep port %d", port)
+		}
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 64
+	}
+	if config.Rate <= 0 {
+		config.Rate = 200
+	}
+	if config.Rate > maxSweepRate {
+		return nil, fmt.Errorf("sweep rate %d is too high (at most %d probes per second)", config.Rate, maxSweepRate)
+	}
+	if config.ProbeTimeout <= 0 {
+		config.ProbeTimeout = time.Second
+	}
+
+	var prefixes []netip.Prefix
+	total := 0
+	for _, cidr := range config.Subnets {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sweep subnet %q", cidr)
+		}
+		prefix = prefix.Masked()
+
+		hostBits := prefix.Addr().BitLen() - prefix.Bits()
+		if hostBits > 16 {
+			return nil, fmt.Errorf("sweep subnet %s is too large (at most %d addresses)", cidr, maxSweepAddresses)
+		}
+		total += 1 << hostBits
+		prefixes = append(prefixes, prefix)
+	}
+	if total > maxSweepAddresses {
+		return nil, fmt.Errorf("sweep subnets cover %d addresses (at most %d)", total, maxSweepAddresses)
+	}
+
+	return &Sweeper{
+		config:   config,
+		prefixes: prefixes,
+		client: &http.Client{
+			Timeout:   config.ProbeTimeout,
+			Transport: config.Transport,
+		},
+	}, nil
+}
+
+// Scan starts a pass over every address and port unless one is running,
+// and waits up to timeout for it to finish. At the configured rate a large
+// subnet takes minutes, so the pass carries on in the background after
+// Scan returns; the result is the agents of the last complete pass plus
+// those the current one has found so far.
+func (s *Sweeper) Scan(timeout time.Duration) ([]*DiscoveredAgent, error) {
+	s.mu.Lock()
+	if s.running == nil {
+		s.running = make(chan struct{})
+		s.current = nil
+		go s.sweep(s.running)
+	}
+	running := s.running
+	s.mu.Unlock()
+
+	select {
+	case <-running:
+	case <-time.After(timeout):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A host answering on several addresses is one agent
+	return merge(s.found, s.current), nil
+}
+
+// sweep probes every address and port once, then closes done. Its duration
+// is governed by the rate limit; each probe has its own timeout.
+func (s *Sweeper) sweep(done chan struct{}) {
+	type target struct {
+		addr string
+		port int
+	}
+
+	targets := make(chan target)
+	go func() {
+		defer close(targets)
+
+		ticker := time.NewTicker(time.Second / time.Duration(s.config.Rate))
+		defer ticker.Stop()
+
+		for _, prefix := range s.prefixes {
+			for _, addr := range hosts(prefix) {
+				for _, port := range s.config.Ports {
+					<-ticker.C
+					targets <- target{addr: addr.String(), port: port}
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range targets {
+				agent := Identify(s.client, t.addr, t.port, SourceSweep)
+				if agent == nil {
+					continue
+				}
+				log.Printf("Discovered agent: %s at %s:%d (sweep)", agent.Instance, t.addr, t.port)
+
+				s.mu.Lock()
+				s.current = append(s.current, agent)
+				s.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.found, s.current = s.current, nil
+	s.running = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+// hosts lists the usable addresses of prefix, skipping the IPv4 network
+// and broadcast addresses of subnets larger than /31
+func hosts(prefix netip.Prefix) []netip.Addr {
+	var addrs []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		addrs = append(addrs, addr)
+		if !addr.Next().IsValid() {
+			break
+		}
+	}
+
+	if prefix.Addr().Is4() && prefix.Bits() < 31 && len(addrs) > 2 {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+	return addrs
+}
+
+// ParsePorts parses port numbers (or service names)
+func ParsePorts(list []string) ([]int, error) {
+	ports := make([]int, 0, len(list))
+	for _, item := range list {
+		port, err := net.LookupPort("tcp", item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
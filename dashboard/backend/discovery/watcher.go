@@ -0,0 +1,327 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// States of an agent in the live discovery table
+const (
+	StatePending    = "pending"    // Seen on the network, not registered
+	StateRegistered = "registered" // Matches a registered agent
+	StateAdopted    = "adopted"    // Registered automatically by an adoption rule
+)
+
+// Entries not seen for this long are dropped from the table
+const seenExpiry = 24 * time.Hour
+
+// AdoptRule selects discovered agents to register automatically.
+// Every condition set on a rule must match; empty conditions match anything.
+type AdoptRule struct {
+	Name     string   `json:"name"`
+	Tags     []string `json:"tags"`     // All tags must be advertised
+	Subnets  []string `json:"subnets"`  // Any address inside any of these CIDRs
+	Hostname string   `json:"hostname"` // Glob on the hostname, e.g. "web-*"
+
+	nets []*net.IPNet
+}
+
+// LoadRules reads adoption rules from a JSON file
+func LoadRules(file string) ([]AdoptRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []AdoptRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse adoption rules: %w", err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if len(rule.Tags) == 0 && len(rule.Subnets) == 0 && rule.Hostname == "" {
+			return nil, fmt.Errorf("adoption rule %q has no conditions", rule.Name)
+		}
+		for _, cidr := range rule.Subnets {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("adoption rule %q: invalid subnet %q", rule.Name, cidr)
+			}
+			rule.nets = append(rule.nets, ipNet)
+		}
+		if _, err := path.Match(rule.Hostname, ""); err != nil {
+			return nil, fmt.Errorf("adoption rule %q: invalid hostname pattern %q", rule.Name, rule.Hostname)
+		}
+	}
+
+	return rules, nil
+}
+
+// Matches reports whether the discovered agent satisfies the rule
+func (r *AdoptRule) Matches(agent *DiscoveredAgent) bool {
+	for _, tag := range r.Tags {
+		if !contains(agent.Tags, tag) {
+			return false
+		}
+	}
+
+	if len(r.nets) > 0 {
+		inSubnet := false
+		for _, addr := range agent.IPs {
+			ip := net.ParseIP(addr)
+			for _, ipNet := range r.nets {
+				if ip != nil && ipNet.Contains(ip) {
+					inSubnet = true
+				}
+			}
+		}
+		if !inSubnet {
+			return false
+		}
+	}
+
+	if r.Hostname != "" {
+		host, _ := path.Match(r.Hostname, normalizeHostname(agent.Hostname))
+		instance, _ := path.Match(r.Hostname, normalizeHostname(agent.Instance))
+		if !host && !instance {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SeenAgent is an entry in the live discovery table
+type SeenAgent struct {
+	*DiscoveredAgent
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	State      string    `json:"state"`
+	AgentID    string    `json:"agent_id,omitempty"`    // Registered agent this maps to
+	AdoptedBy  string    `json:"adopted_by,omitempty"`  // Rule that adopted it
+	AdoptError string    `json:"adopt_error,omitempty"` // Last failed adoption attempt
+}
+
+type WatcherConfig struct {
+	Interval    time.Duration
+	ScanTimeout time.Duration
+	Rules       []AdoptRule
+}
+
+// AdoptFunc registers a discovered agent and returns the stored agent
+type AdoptFunc func(agent *DiscoveredAgent) (*storage.Agent, error)
+
+// Watcher runs discovery in the background, keeps a table of every
+// agent seen, follows address changes of registered agents and adopts new
+// agents matching the configured rules
+type Watcher struct {
+	scanner  Source
+	store    storage.Store
+	client   *http.Client
+	config   WatcherConfig
+	adopt    AdoptFunc
+	seen     map[string]*SeenAgent
+	lastScan time.Time
+	mu       sync.RWMutex
+	stopChan chan struct{}
+}
+
+func NewWatcher(scanner Source, store storage.Store, client *http.Client, config WatcherConfig, adopt AdoptFunc) *Watcher {
+	return &Watcher{
+		scanner:  scanner,
+		store:    store,
+		client:   client,
+		config:   config,
+		adopt:    adopt,
+		seen:     make(map[string]*SeenAgent),
+		stopChan: make(chan struct{}),
+	}
+}
+
+func (w *Watcher) Start() {
+	ticker := time.NewTicker(w.config.Interval)
+	go func() {
+		// Scan immediately on start
+		w.scan()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.scan()
+			case <-w.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	log.Printf("Background discovery started (interval: %v, %d adoption rules)", w.config.Interval, len(w.config.Rules))
+}
+
+func (w *Watcher) Stop() {
+	close(w.stopChan)
+	log.Println("Background discovery stopped")
+}
+
+// LastScan returns when the last scan completed (zero before the first one)
+func (w *Watcher) LastScan() time.Time {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.lastScan
+}
+
+// Agents returns the discovery table, optionally filtered by state
+func (w *Watcher) Agents(state string) []SeenAgent {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	agents := make([]SeenAgent, 0, len(w.seen))
+	for _, entry := range w.seen {
+		if state == "" || entry.State == state {
+			agents = append(agents, *entry)
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].FirstSeen.Before(agents[j].FirstSeen)
+	})
+	return agents
+}
+
+// Present returns the entries in state seen by one of the last two scans,
+// leaving out agents that went away but haven't expired yet
+func (w *Watcher) Present(state string) []SeenAgent {
+	cutoff := time.Now().Add(-2 * (w.config.Interval + w.config.ScanTimeout))
+
+	var agents []SeenAgent
+	for _, entry := range w.Agents(state) {
+		if entry.LastSeen.After(cutoff) {
+			agents = append(agents, entry)
+		}
+	}
+	return agents
+}
+
+func (w *Watcher) scan() {
+	discovered, err := w.scanner.Scan(w.config.ScanTimeout)
+	if err != nil {
+		log.Printf("Background discovery failed: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, disc := range discovered {
+		w.process(disc, now)
+	}
+
+	w.mu.Lock()
+	for key, entry := range w.seen {
+		if now.Sub(entry.LastSeen) > seenExpiry {
+			delete(w.seen, key)
+		}
+	}
+	w.lastScan = now
+	w.mu.Unlock()
+}
+
+func (w *Watcher) process(disc *DiscoveredAgent, now time.Time) {
+	key := disc.ID
+	if key == "" {
+		key = fmt.Sprintf("%s:%d", normalizeHostname(disc.Instance), disc.Port)
+	}
+
+	w.mu.Lock()
+	entry, exists := w.seen[key]
+	if !exists {
+		entry = &SeenAgent{FirstSeen: now, State: StatePending}
+		w.seen[key] = entry
+	}
+	entry.DiscoveredAgent = disc
+	entry.LastSeen = now
+	w.mu.Unlock()
+
+	registered, match := FindRegistered(disc, w.store.GetAllAgents())
+	if registered != nil {
+		w.followAddress(registered, disc, match)
+
+		w.mu.Lock()
+		if entry.State != StateAdopted {
+			entry.State = StateRegistered
+		}
+		entry.AgentID = registered.ID
+		w.mu.Unlock()
+		return
+	}
+
+	w.mu.Lock()
+	if entry.State != StatePending {
+		// Agent was removed from the dashboard since we last saw it
+		entry.State = StatePending
+		entry.AgentID = ""
+		entry.AdoptedBy = ""
+	}
+	w.mu.Unlock()
+
+	for _, rule := range w.config.Rules {
+		if !rule.Matches(disc) {
+			continue
+		}
+
+		agent, err := w.adopt(disc)
+
+		w.mu.Lock()
+		if err != nil {
+			entry.AdoptError = err.Error()
+			log.Printf("Failed to adopt %s (rule %s): %v", disc.Instance, rule.Name, err)
+		} else {
+			entry.State = StateAdopted
+			entry.AgentID = agent.ID
+			entry.AdoptedBy = rule.Name
+			entry.AdoptError = ""
+			log.Printf("Auto-adopted %s as %s (rule %s)", disc.Instance, agent.ID, rule.Name)
+		}
+		w.mu.Unlock()
+		return
+	}
+}
+
+// followAddress updates a registered agent whose address changed (DHCP).
+// Only an agent ID match is trusted: another host may share the hostname,
+// and an address match says nothing new.
+func (w *Watcher) followAddress(agent *storage.Agent, disc *DiscoveredAgent, match MatchKind) {
+	if match != MatchID {
+		return
+	}
+	if agent.Port != disc.Port || contains(disc.IPs, agent.IPAddress) {
+		return
+	}
+
+	addresses := storage.SortAddresses(disc.IPs, agent.Family)
+	if len(addresses) == 0 {
+		return
+	}
+
+	// Only switch to an address that actually answers
+	reachable := Probe(w.client, agent.Scheme, addresses, disc.Port)
+	if len(reachable) == 0 {
+		log.Printf("Agent %s advertises %v but none is reachable", agent.ID, addresses)
+		return
+	}
+
+	log.Printf("Agent %s moved from %s to %s", agent.ID, agent.IPAddress, reachable[0])
+	if err := w.store.UpdateAgentAddress(agent.ID, reachable[0], addresses); err != nil {
+		log.Printf("Failed to update address for %s: %v", agent.ID, err)
+	}
+}
@@ -1,65 +1,315 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/AzertoxHDW/sentinel/dashboard/backend/api"
 	"github.com/AzertoxHDW/sentinel/dashboard/backend/collector"
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/discovery"
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/remotewrite"
 	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+	"github.com/AzertoxHDW/sentinel/internal/netiface"
 )
 
 func main() {
 	port := flag.String("port", "8080", "Port to listen on")
-	dataFile := flag.String("data", "agents.json", "Agent storage file")
+	dbFile := flag.String("db", "sentinel.db", "Agent database file")
+	dataFile := flag.String("data", "agents.json", "Legacy agent file imported into the database on first start")
 	collectInterval := flag.Duration("interval", 30*time.Second, "Metrics collection interval")
-	
-	// InfluxDB config
+	pollWorkers := flag.Int("workers", 16, "Agents polled concurrently")
+	pollTimeout := flag.Duration("poll-timeout", 5*time.Second, "Deadline for polling one agent")
+	pollJitter := flag.Duration("jitter", -1, "Spread the first agent polls over this much time (default half the interval)")
+	maxClockSkew := flag.Duration("max-clock-skew", 5*time.Second, "Store dashboard time for samples from agents whose clock is further off")
+	maxBackoff := flag.Duration("max-backoff", 10*time.Minute, "Longest delay between polls of an offline agent")
+	slowThreshold := flag.Duration("slow-threshold", time.Duration(storage.DefaultThresholds.SlowResponseMS)*time.Millisecond, "Polls slower than this mark an agent degraded")
+	staleAfter := flag.Int("stale-after", storage.DefaultThresholds.StaleAfter, "Consecutive missed polls before an agent is stale")
+	offlineAfter := flag.Int("offline-after", storage.DefaultThresholds.OfflineAfter, "Consecutive missed polls before an agent is offline")
+	discoveryInterval := flag.Duration("discovery-interval", time.Minute, "Background mDNS discovery interval (0 to disable)")
+	adoptRules := flag.String("auto-adopt-rules", "", "JSON file with rules for adopting discovered agents automatically")
+	mdnsInterfaces := flag.String("mdns-interfaces", "", "Comma separated interfaces to browse for agents (globs, default all)")
+	mdnsExclude := flag.String("mdns-exclude-interfaces", "", "Comma separated interfaces never browsed (globs, e.g. docker*,veth*)")
+	mdnsEnabled := flag.Bool("mdns", true, "Discover agents via mDNS")
+	sweepSubnets := flag.String("sweep-subnets", "", "Comma separated CIDR ranges probed for agents where multicast is blocked")
+	sweepPorts := flag.String("sweep-ports", "9100", "Comma separated ports probed on every sweep address")
+	sweepConcurrency := flag.Int("sweep-concurrency", 64, "Maximum sweep probes in flight")
+	sweepRate := flag.Int("sweep-rate", 200, "Maximum sweep probes started per second")
+	dnssdDomain := flag.String("dnssd-domain", "", "Domain to browse for _sentinel._tcp with unicast DNS-SD")
+	dnssdResolver := flag.String("dnssd-resolver", "", "DNS server for DNS-SD queries (host[:port], default from /etc/resolv.conf)")
+	seedFile := flag.String("seed-file", "", "File listing agent addresses (host[:port]), one per line")
+
+	// Agent connections
+	agentToken := flag.String("agent-token", "", "Bearer token for agents that require one and weren't given their own (or SENTINEL_AGENT_TOKEN)")
+	agentCA := flag.String("agent-ca", "", "PEM file of CA certificates trusted for agents serving TLS")
+	agentInsecure := flag.Bool("agent-tls-skip-verify", false, "Don't verify the certificates of agents serving TLS")
+
+	// Metrics storage
+	metricsBackend := flag.String("metrics-backend", "", "Time-series backend: embedded, influx, or none to only forward samples with remote write (default influx when an InfluxDB token is set, embedded otherwise)")
+	metricsDB := flag.String("metrics-db", "metrics.db", "Embedded metrics database file")
+	retention := flag.Duration("retention", -1, "Delete raw metrics older than this, 0 keeps everything (default 720h embedded, 0 with InfluxDB)")
+	rollups := flag.Bool("rollups", true, "Keep 5 minute and hourly rollups once raw metrics are deleted")
+	retention5m := flag.Duration("retention-5m", 180*24*time.Hour, "Delete 5 minute rollups older than this, 0 keeps everything")
+	retention1h := flag.Duration("retention-1h", 5*365*24*time.Hour, "Delete hourly rollups older than this, 0 keeps everything")
+
+	// Prometheus remote write, alongside the metrics backend or instead of
+	// it with -metrics-backend=none
+	remoteWriteURL := flag.String("remote-write-url", "", "Prometheus remote-write endpoint to forward samples to")
+	remoteWriteUsername := flag.String("remote-write-username", "", "Basic auth user for remote write")
+	remoteWritePassword := flag.String("remote-write-password", "", "Basic auth password for remote write (or REMOTE_WRITE_PASSWORD)")
+	remoteWriteToken := flag.String("remote-write-bearer-token", "", "Bearer token for remote write (or REMOTE_WRITE_BEARER_TOKEN)")
+	remoteWriteBatch := flag.Int("remote-write-batch", 500, "Most series per remote-write request")
+	remoteWriteFlush := flag.Duration("remote-write-flush", 5*time.Second, "Send a partial remote-write batch after this long")
+	remoteWriteRetries := flag.Int("remote-write-retries", 5, "Retries for a failed remote-write batch")
+
+	// InfluxDB config, used with -metrics-backend=influx
 	influxURL := flag.String("influx-url", "http://localhost:8086", "InfluxDB URL")
 	influxToken := flag.String("influx-token", "", "InfluxDB token")
 	influxOrg := flag.String("influx-org", "sentinel", "InfluxDB organization")
 	influxBucket := flag.String("influx-bucket", "metrics", "InfluxDB bucket")
-	
+	influxBatch := flag.Int("influx-batch", 5000, "Most points per InfluxDB write")
+	influxFlush := flag.Duration("influx-flush", time.Second, "Write a partial InfluxDB batch after this long")
+	influxMaxPending := flag.Int("influx-max-pending", 100000, "Points queued for InfluxDB before new samples are refused")
+	influxRetries := flag.Int("influx-retries", 5, "Retries for a failed InfluxDB write")
+
 	flag.Parse()
 
 	finalInfluxToken := *influxToken // Start with the flag value (if set in command)
-	
+
 	// Explicitly check the environment variable INFLUX_TOKEN
 	if envToken, exists := os.LookupEnv("INFLUX_TOKEN"); exists && envToken != "" {
 		// Use the environment variable, overriding the flag if both are set
-		finalInfluxToken = envToken 
+		finalInfluxToken = envToken
 	}
-	
-	if finalInfluxToken == "" {
-		log.Fatal("InfluxDB token is required. Use -influx-token flag or set INFLUX_TOKEN env var")
+
+	// Deployments that only pass an InfluxDB token keep writing to InfluxDB
+	if *metricsBackend == "" {
+		*metricsBackend = "embedded"
+		if finalInfluxToken != "" {
+			*metricsBackend = "influx"
+		}
+	} else if *metricsBackend == "embedded" && finalInfluxToken != "" {
+		log.Printf("Ignoring the InfluxDB token: -metrics-backend=embedded stores metrics in %s", *metricsDB)
+	} else if *metricsBackend == "none" && *remoteWriteURL == "" {
+		log.Fatal("-metrics-backend=none needs -remote-write-url, or samples would go nowhere")
+	}
+
+	if env := os.Getenv("SENTINEL_AGENT_TOKEN"); env != "" {
+		*agentToken = env
+	}
+	agentTransport, err := newAgentTransport(*agentCA, *agentInsecure)
+	if err != nil {
+		log.Fatalf("Invalid agent TLS configuration: %v", err)
 	}
 
 	// Initialize storage
-	store, err := storage.NewStore(*dataFile)
+	store, err := storage.NewBoltStore(*dbFile)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
+	defer store.Close()
 
-	// Initialize InfluxDB
-	influxDB := storage.NewInfluxDB(storage.InfluxConfig{
-		URL:    *influxURL,
-		Token:  finalInfluxToken,
-		Org:    *influxOrg,
-		Bucket: *influxBucket,
-	})
-	defer influxDB.Close()
+	if err := store.ImportJSON(*dataFile); err != nil {
+		log.Fatalf("Failed to import agents: %v", err)
+	}
+
+	// Raw points are kept for -retention, then only as rollups. With
+	// InfluxDB the bucket's own retention policy applies unless -retention
+	// is set, and there is nothing to roll up for.
+	if *retention < 0 {
+		*retention = 30 * 24 * time.Hour
+		if *metricsBackend == "influx" {
+			*retention = 0
+		}
+	}
+	tiers := storage.Tiers{{Name: storage.RawTier.Name, Keep: *retention}}
+	if *rollups && *retention > 0 {
+		tiers = append(tiers,
+			storage.Tier{Name: "5m", Resolution: 5 * time.Minute, Keep: *retention5m},
+			storage.Tier{Name: "1h", Resolution: time.Hour, Keep: *retention1h},
+		)
+	}
+	if err := tiers.Validate(); err != nil {
+		log.Fatalf("Invalid retention: %v", err)
+	}
+
+	// Initialize the metrics store
+	var metrics storage.MetricsStore
+	switch *metricsBackend {
+	case "embedded":
+		metrics, err = storage.NewBoltMetrics(*metricsDB, tiers)
+		if err != nil {
+			log.Fatalf("Failed to initialize metrics storage: %v", err)
+		}
+		log.Printf("Storing metrics in %s", *metricsDB)
+	case "none":
+		metrics = storage.NoMetrics{}
+		log.Printf("Not storing metrics: samples are only sent to %s", *remoteWriteURL)
+	case "influx":
+		if finalInfluxToken == "" {
+			log.Fatal("InfluxDB token is required. Use -influx-token flag or set INFLUX_TOKEN env var")
+		}
+		metrics = storage.NewInfluxDB(storage.InfluxConfig{
+			URL:    *influxURL,
+			Token:  finalInfluxToken,
+			Org:    *influxOrg,
+			Bucket: *influxBucket,
+
+			BatchSize:     *influxBatch,
+			FlushInterval: *influxFlush,
+			MaxPending:    *influxMaxPending,
+			MaxRetries:    *influxRetries,
+			Tiers:         tiers,
+		})
+		log.Printf("Storing metrics in InfluxDB at %s", *influxURL)
+	default:
+		log.Fatalf("Unknown metrics backend %q: use embedded, influx or none", *metricsBackend)
+	}
+	defer metrics.Close()
+
+	var metricsRetention *storage.Retention
+	if *retention > 0 && *metricsBackend != "none" {
+		metricsRetention = storage.NewRetention(metrics, tiers)
+		metricsRetention.Start()
+	}
 
 	// Start metrics collector
-	metricsCollector := collector.NewMetricsCollector(store, influxDB, *collectInterval)
+	thresholds := storage.Thresholds{
+		SlowResponseMS: int(slowThreshold.Milliseconds()),
+		StaleAfter:     *staleAfter,
+		OfflineAfter:   *offlineAfter,
+	}
+	if thresholds.SlowResponseMS <= 0 || thresholds.StaleAfter < 1 || thresholds.OfflineAfter < thresholds.StaleAfter {
+		log.Fatal("Invalid status thresholds: need -slow-threshold > 0 and 1 <= -stale-after <= -offline-after")
+	}
+	metricsCollector := collector.NewMetricsCollector(store, metrics, collector.Config{
+		Interval:     *collectInterval,
+		Thresholds:   thresholds,
+		Workers:      *pollWorkers,
+		Timeout:      *pollTimeout,
+		Jitter:       *pollJitter,
+		MaxBackoff:   *maxBackoff,
+		MaxClockSkew: *maxClockSkew,
+		Transport:    agentTransport,
+	})
+
+	var remoteWrite *remotewrite.Writer
+	if *remoteWriteURL != "" {
+		if env := os.Getenv("REMOTE_WRITE_PASSWORD"); env != "" {
+			*remoteWritePassword = env
+		}
+		if env := os.Getenv("REMOTE_WRITE_BEARER_TOKEN"); env != "" {
+			*remoteWriteToken = env
+		}
+		remoteWrite, err = remotewrite.NewWriter(remotewrite.Config{
+			URL:           *remoteWriteURL,
+			Username:      *remoteWriteUsername,
+			Password:      *remoteWritePassword,
+			BearerToken:   *remoteWriteToken,
+			BatchSize:     *remoteWriteBatch,
+			FlushInterval: *remoteWriteFlush,
+			MaxRetries:    *remoteWriteRetries,
+		})
+		if err != nil {
+			log.Fatalf("Invalid remote write configuration: %v", err)
+		}
+		remoteWrite.Start()
+		metricsCollector.AddSink(remoteWrite)
+	}
+
 	metricsCollector.Start()
 	defer metricsCollector.Stop()
 
+	// Create discovery sources
+	scannerConfig := discovery.ScannerConfig{
+		Interfaces:        splitList(*mdnsInterfaces),
+		ExcludeInterfaces: splitList(*mdnsExclude),
+	}
+	for _, patterns := range [][]string{scannerConfig.Interfaces, scannerConfig.ExcludeInterfaces} {
+		if err := netiface.ValidatePatterns(patterns); err != nil {
+			log.Fatalf("Invalid mDNS interface selection: %v", err)
+		}
+	}
+	var sources discovery.Sources
+	if *mdnsEnabled {
+		sources = append(sources, discovery.NewScanner(scannerConfig))
+	}
+
+	// Create subnet sweeper
+	if subnets := splitList(*sweepSubnets); len(subnets) > 0 {
+		ports, err := discovery.ParsePorts(splitList(*sweepPorts))
+		if err != nil {
+			log.Fatalf("Invalid sweep ports: %v", err)
+		}
+		sweeper, err := discovery.NewSweeper(discovery.SweepConfig{
+			Subnets:     subnets,
+			Ports:       ports,
+			Concurrency: *sweepConcurrency,
+			Rate:        *sweepRate,
+			Transport:   agentTransport,
+		})
+		if err != nil {
+			log.Fatalf("Invalid sweep configuration: %v", err)
+		}
+		sources = append(sources, sweeper)
+	}
+
+	// Unicast DNS-SD
+	if *dnssdDomain != "" {
+		dnssd, err := discovery.NewDNSSD(discovery.DNSSDConfig{
+			Domain:   *dnssdDomain,
+			Resolver: *dnssdResolver,
+		})
+		if err != nil {
+			log.Fatalf("Invalid DNS-SD configuration: %v", err)
+		}
+		sources = append(sources, dnssd)
+	}
+
+	// Static seed list
+	if *seedFile != "" {
+		seeds, err := discovery.NewSeeds(*seedFile, agentTransport)
+		if err != nil {
+			log.Fatalf("Failed to load seed file: %v", err)
+		}
+		sources = append(sources, seeds)
+	}
+
 	// Create API server
-	server := api.NewServer(store, influxDB, *port)
+	server := api.NewServer(store, metrics, sources, *port)
+	if agentTransport != nil {
+		server.SetAgentTransport(agentTransport)
+	}
+	server.SetAgentToken(*agentToken)
+	server.SetCollector(metricsCollector)
+	if remoteWrite != nil {
+		server.SetRemoteWrite(remoteWrite)
+	}
+
+	// Start background discovery
+	if *discoveryInterval > 0 {
+		var rules []discovery.AdoptRule
+		if *adoptRules != "" {
+			rules, err = discovery.LoadRules(*adoptRules)
+			if err != nil {
+				log.Fatalf("Failed to load adoption rules: %v", err)
+			}
+		}
+		server.StartDiscovery(discovery.WatcherConfig{
+			Interval:    *discoveryInterval,
+			ScanTimeout: 3 * time.Second,
+			Rules:       rules,
+		})
+		defer server.StopDiscovery()
+	}
 
 	// Handle graceful shutdown
 	go func() {
@@ -67,8 +317,16 @@ func main() {
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 		<-sigChan
 		log.Println("Shutting down dashboard...")
+		server.StopDiscovery()
 		metricsCollector.Stop()
-		influxDB.Close()
+		if remoteWrite != nil {
+			remoteWrite.Stop()
+		}
+		if metricsRetention != nil {
+			metricsRetention.Stop()
+		}
+		metrics.Close()
+		store.Close()
 		os.Exit(0)
 	}()
 
@@ -76,4 +334,43 @@ func main() {
 	if err := server.Start(); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// newAgentTransport returns the transport for agent requests, or nil when
+// the default one will do. It is kept apart from the default transport so
+// the agent TLS settings don't apply to InfluxDB or remote write.
+func newAgentTransport(caFile string, insecure bool) (http.RoundTripper, error) {
+	if caFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
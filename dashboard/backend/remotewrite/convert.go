@@ -0,0 +1,62 @@
+package remotewrite
+
+import (
+	"time"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// convert turns one agent sample into time series named like the agent's
+// own /metrics/prometheus endpoint, with an agent_id label added
+func convert(agentID string, m *storage.SystemMetrics) []TimeSeries {
+	timestamp := m.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	ms := timestamp.UnixMilli()
+
+	var series []TimeSeries
+	add := func(name string, value float64, extra ...Label) {
+		labels := append([]Label{
+			{Name: "__name__", Value: name},
+			{Name: "agent_id", Value: agentID},
+			{Name: "hostname", Value: m.Hostname},
+		}, extra...)
+		sortLabels(labels)
+		series = append(series, TimeSeries{Labels: labels, Samples: []Sample{{Value: value, Timestamp: ms}}})
+	}
+
+	add("sentinel_uptime_seconds", float64(m.Uptime))
+	add("sentinel_cpu_usage_percent", m.CPUPercent)
+	add("sentinel_cpu_cores", float64(m.CoreCount))
+	if len(m.LoadAvg) == 3 {
+		for i, period := range []string{"1m", "5m", "15m"} {
+			add("sentinel_load_average", m.LoadAvg[i], Label{Name: "period", Value: period})
+		}
+	}
+
+	add("sentinel_memory_total_bytes", float64(m.MemTotal))
+	add("sentinel_memory_used_bytes", float64(m.MemUsed))
+	add("sentinel_memory_available_bytes", float64(m.MemAvailable))
+
+	for _, d := range m.Disks {
+		labels := []Label{
+			{Name: "device", Value: d.Device},
+			{Name: "mount_point", Value: d.MountPoint},
+			{Name: "fs_type", Value: d.FSType},
+		}
+		add("sentinel_disk_total_bytes", float64(d.Total), labels...)
+		add("sentinel_disk_used_bytes", float64(d.Used), labels...)
+		add("sentinel_disk_free_bytes", float64(d.Free), labels...)
+	}
+
+	for _, n := range m.Networks {
+		iface := Label{Name: "interface", Value: n.Interface}
+		add("sentinel_network_sent_bytes_total", float64(n.BytesSent), iface)
+		add("sentinel_network_received_bytes_total", float64(n.BytesRecv), iface)
+		add("sentinel_network_sent_packets_total", float64(n.PacketsSent), iface)
+		add("sentinel_network_received_packets_total", float64(n.PacketsRecv), iface)
+	}
+
+	return series
+}
@@ -0,0 +1,84 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// Label and Sample mirror the prometheus.WriteRequest protobuf messages
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64 // Unix milliseconds
+}
+
+// TimeSeries is one sample of one series; label names must be sorted
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// marshalWriteRequest encodes series as a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// The messages are small enough that hand encoding them is simpler than
+// pulling in a protobuf runtime.
+func marshalWriteRequest(series []TimeSeries) []byte {
+	var buf, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.Labels {
+			msg = msg[:0]
+			msg = appendString(msg, 1, l.Name)
+			msg = appendString(msg, 2, l.Value)
+			ts = appendBytes(ts, 1, msg)
+		}
+		for _, sample := range s.Samples {
+			msg = msg[:0]
+			msg = appendTag(msg, 1, wireFixed64)
+			msg = binary.LittleEndian.AppendUint64(msg, math.Float64bits(sample.Value))
+			msg = appendTag(msg, 2, wireVarint)
+			msg = binary.AppendUvarint(msg, uint64(sample.Timestamp))
+			ts = appendBytes(ts, 2, msg)
+		}
+		buf = appendBytes(buf, 1, ts)
+	}
+	return buf
+}
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytes(b []byte, field int, value []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendString(b []byte, field int, value string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// sortLabels orders labels by name, as remote-write receivers require
+func sortLabels(labels []Label) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+}
@@ -0,0 +1,149 @@
+package remotewrite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// goldenSeries encodes to goldenRequest
+var goldenSeries = []TimeSeries{
+	{
+		Labels: []Label{
+			{Name: "__name__", Value: "sentinel_cpu_usage_percent"},
+			{Name: "agent_id", Value: "agent-1"},
+			{Name: "hostname", Value: "web-1"},
+		},
+		Samples: []Sample{{Value: 42.5, Timestamp: 1700000000123}},
+	},
+	{
+		Labels:  []Label{{Name: "__name__", Value: "sentinel_up"}},
+		Samples: []Sample{{Value: -1.25, Timestamp: 1700000000124}},
+	},
+}
+
+// goldenRequest is goldenSeries marshaled by prompb.WriteRequest from
+// github.com/prometheus/prometheus v0.54.1, what receivers decode with
+const goldenRequest = "0a620a260a085f5f6e616d655f5f121a73656e74696e656c5f6370755f757361" +
+	"67655f70657263656e740a130a086167656e745f696412076167656e742d310a" +
+	"110a08686f73746e616d6512057765622d31121009000000000040454010fbd0" +
+	"95ffbc310a2b0a170a085f5f6e616d655f5f120b73656e74696e656c5f757012" +
+	"1009000000000000f4bf10fcd095ffbc31"
+
+func TestMarshalWriteRequestMatchesPrompb(t *testing.T) {
+	want, err := hex.DecodeString(goldenRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := marshalWriteRequest(goldenSeries); !bytes.Equal(got, want) {
+		t.Errorf("marshalWriteRequest =\n%x\nwant\n%x", got, want)
+	}
+}
+
+// The receiver in the writer tests decodes with unmarshalWriteRequest, so
+// check it against prompb too
+func TestUnmarshalWriteRequestReadsPrompb(t *testing.T) {
+	b, err := hex.DecodeString(goldenRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := unmarshalWriteRequest(b)
+	if err != nil {
+		t.Fatalf("unmarshalWriteRequest: %v", err)
+	}
+	if !reflect.DeepEqual(series, goldenSeries) {
+		t.Errorf("unmarshalWriteRequest = %+v, want %+v", series, goldenSeries)
+	}
+}
+
+// unmarshalWriteRequest decodes the prometheus.WriteRequest messages that
+// marshalWriteRequest produces
+func unmarshalWriteRequest(b []byte) ([]TimeSeries, error) {
+	var series []TimeSeries
+	err := eachField(b, func(field, wireType int, value []byte, _ uint64) error {
+		if field != 1 || wireType != wireBytes {
+			return fmt.Errorf("unexpected WriteRequest field %d", field)
+		}
+		var ts TimeSeries
+		err := eachField(value, func(field, wireType int, value []byte, _ uint64) error {
+			switch {
+			case field == 1 && wireType == wireBytes:
+				var l Label
+				err := eachField(value, func(field, wireType int, value []byte, _ uint64) error {
+					switch field {
+					case 1:
+						l.Name = string(value)
+					case 2:
+						l.Value = string(value)
+					}
+					return nil
+				})
+				ts.Labels = append(ts.Labels, l)
+				return err
+			case field == 2 && wireType == wireBytes:
+				var s Sample
+				err := eachField(value, func(field, wireType int, value []byte, n uint64) error {
+					switch {
+					case field == 1 && wireType == wireFixed64:
+						s.Value = math.Float64frombits(binary.LittleEndian.Uint64(value))
+					case field == 2 && wireType == wireVarint:
+						s.Timestamp = int64(n)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+			return fmt.Errorf("unexpected TimeSeries field %d", field)
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+// eachField walks the fields of a protobuf message. Length-delimited and
+// fixed64 values are passed as bytes, varints as n.
+func eachField(b []byte, fn func(field, wireType int, value []byte, n uint64) error) error {
+	for len(b) > 0 {
+		tag, size := binary.Uvarint(b)
+		if size <= 0 {
+			return fmt.Errorf("bad tag")
+		}
+		b = b[size:]
+		field, wireType := int(tag>>3), int(tag&7)
+
+		var value []byte
+		var n uint64
+		switch wireType {
+		case wireVarint:
+			n, size = binary.Uvarint(b)
+			if size <= 0 {
+				return fmt.Errorf("bad varint in field %d", field)
+			}
+			b = b[size:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("short fixed64 in field %d", field)
+			}
+			value, b = b[:8], b[8:]
+		case wireBytes:
+			length, size := binary.Uvarint(b)
+			if size <= 0 || uint64(len(b)-size) < length {
+				return fmt.Errorf("bad length in field %d", field)
+			}
+			b = b[size:]
+			value, b = b[:length], b[length:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wireType)
+		}
+		if err := fn(field, wireType, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package remotewrite sends collected samples to a Prometheus remote-write
+// endpoint.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/batch"
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// Config for a remote-write endpoint
+type Config struct {
+	URL string
+	// Basic auth, or a bearer token; at most one of them
+	Username    string
+	Password    string
+	BearerToken string
+	// BatchSize is the most series sent per request
+	BatchSize int
+	// FlushInterval sends a partial batch after this long
+	FlushInterval time.Duration
+	// MaxPending bounds the series waiting to be sent, including a batch
+	// being retried; the oldest are dropped when the endpoint can't keep up
+	MaxPending int
+	// MaxRetries for a batch that failed with a network error, 429 or 5xx
+	MaxRetries int
+	Timeout    time.Duration
+}
+
+// Stats describes what the writer has sent
+type Stats struct {
+	SentSeries    uint64     `json:"sent_series"`
+	SentBatches   uint64     `json:"sent_batches"`
+	FailedBatches uint64     `json:"failed_batches"` // Given up after retries or rejected
+	Retries       uint64     `json:"retries"`
+	Dropped       uint64     `json:"dropped_series"` // Dropped because the queue was full
+	Pending       int        `json:"pending_series"`
+	Retrying      bool       `json:"retrying"` // A failed batch is waiting for its next attempt
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	LastSentAt    *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// Writer batches series and sends them from a background goroutine, so a
+// slow endpoint never holds up polling
+type Writer struct {
+	config Config
+	client *http.Client
+	queue  *batch.Queue[TimeSeries]
+}
+
+func NewWriter(config Config) (*Writer, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("remote write URL required")
+	}
+	if config.BearerToken != "" && (config.Username != "" || config.Password != "") {
+		return nil, fmt.Errorf("use either basic auth or a bearer token for remote write, not both")
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	w := &Writer{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+	w.queue = batch.New(batch.Config{
+		BatchSize:     config.BatchSize,
+		FlushInterval: config.FlushInterval,
+		MaxPending:    config.MaxPending,
+		MaxRetries:    config.MaxRetries,
+		MinBackoff:    500 * time.Millisecond,
+		MaxBackoff:    30 * time.Second,
+		DropOldest:    true,
+	}, w.sendBatch, w.failed)
+
+	queued := w.queue.Config()
+	w.config.BatchSize = queued.BatchSize
+	w.config.MaxPending = queued.MaxPending
+	w.config.MaxRetries = queued.MaxRetries
+	return w, nil
+}
+
+func (w *Writer) Start() {
+	w.queue.Start()
+	log.Printf("Remote write started (url: %s, batch: %d, flush: %v)", w.config.URL, w.config.BatchSize, w.config.FlushInterval)
+}
+
+// Stop sends what is still pending and waits for it
+func (w *Writer) Stop() {
+	w.queue.Stop()
+	log.Println("Remote write stopped")
+}
+
+// Stats returns the writer's counters
+func (w *Writer) Stats() Stats {
+	stats := w.queue.Stats()
+	return Stats{
+		SentSeries:    stats.Sent,
+		SentBatches:   stats.SentBatches,
+		FailedBatches: stats.FailedBatches,
+		Retries:       stats.Retries,
+		Dropped:       stats.Overflowed,
+		Pending:       stats.Pending,
+		Retrying:      stats.Retrying,
+		LastError:     stats.LastError,
+		LastErrorAt:   stats.LastErrorAt,
+		LastSentAt:    stats.LastSentAt,
+	}
+}
+
+// WriteMetrics queues one agent sample; it never blocks on the network.
+// When the endpoint can't keep up the oldest queued series are dropped.
+func (w *Writer) WriteMetrics(agentID string, metrics *storage.SystemMetrics) error {
+	return w.queue.Add(convert(agentID, metrics)...)
+}
+
+// sendBatch makes one attempt at posting a batch
+func (w *Writer) sendBatch(series []TimeSeries) (bool, time.Duration, error) {
+	retry, err := w.post(snappy.Encode(nil, marshalWriteRequest(series)))
+	return retry, 0, err
+}
+
+func (w *Writer) failed(series []TimeSeries, err error, giveUp bool, wait time.Duration) {
+	if giveUp {
+		log.Printf("Remote write failed, dropping %d series: %v", len(series), err)
+		return
+	}
+	log.Printf("Remote write of %d series failed, retrying in %v: %v", len(series), wait, err)
+}
+
+// post sends one encoded request and reports whether a failure is worth
+// retrying: network errors, 429 and 5xx are; other statuses mean the
+// receiver rejected the data
+func (w *Writer) post(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "sentinel-dashboard")
+	switch {
+	case w.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.config.BearerToken)
+	case w.config.Username != "":
+		req.SetBasicAuth(w.config.Username, w.config.Password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+}
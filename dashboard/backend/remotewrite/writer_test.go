@@ -0,0 +1,284 @@
+package remotewrite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/storage"
+)
+
+// receiver is a remote-write endpoint answering with the queued statuses,
+// then 204, and keeping every request it got
+type receiver struct {
+	*httptest.Server
+	t        *testing.T
+	statuses []int
+	requests []*receivedRequest
+	mu       sync.Mutex
+}
+
+type receivedRequest struct {
+	header http.Header
+	series []TimeSeries
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{t: t, statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) handle(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("reading body: %v", err)
+	}
+	if got := req.Header.Get("Content-Encoding"); got != "snappy" {
+		r.t.Errorf("Content-Encoding = %q, want snappy", got)
+	}
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		r.t.Errorf("snappy decoding body: %v", err)
+	}
+	series, err := unmarshalWriteRequest(raw)
+	if err != nil {
+		r.t.Errorf("decoding WriteRequest: %v", err)
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, &receivedRequest{header: req.Header.Clone(), series: series})
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() []*receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*receivedRequest(nil), r.requests...)
+}
+
+var sampleTime = time.UnixMilli(1700000000123)
+
+// send queues one sample, waits until the writer is done with it and
+// stops the writer
+func send(t *testing.T, config Config) *Writer {
+	t.Helper()
+	config.FlushInterval = 10 * time.Millisecond
+	w, err := NewWriter(config)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+	queueSample(t, w)
+	eventually(t, func() bool {
+		stats := w.Stats()
+		return stats.SentBatches+stats.FailedBatches > 0
+	})
+	w.Stop()
+	return w
+}
+
+func queueSample(t *testing.T, w *Writer) {
+	t.Helper()
+	err := w.WriteMetrics("agent-1", &storage.SystemMetrics{
+		Timestamp:  sampleTime,
+		Hostname:   "web-1",
+		CPUPercent: 42.5,
+		Networks:   []storage.NetworkMetric{{Interface: "eth0", BytesRecv: 1024}},
+	})
+	if err != nil {
+		t.Fatalf("WriteMetrics: %v", err)
+	}
+}
+
+// eventually waits for done to hold, failing the test after a few seconds
+func eventually(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriterEncodesSeries(t *testing.T) {
+	r := newReceiver(t)
+	send(t, Config{URL: r.URL})
+
+	requests := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	series := requests[0].series
+	if len(series) == 0 {
+		t.Fatal("request holds no series")
+	}
+
+	var cpu *TimeSeries
+	for i, s := range series {
+		if !sort.SliceIsSorted(s.Labels, func(a, b int) bool { return s.Labels[a].Name < s.Labels[b].Name }) {
+			t.Errorf("labels of series %d not sorted: %v", i, s.Labels)
+		}
+		if len(s.Samples) != 1 || s.Samples[0].Timestamp != sampleTime.UnixMilli() {
+			t.Errorf("series %d samples = %v, want one at %d", i, s.Samples, sampleTime.UnixMilli())
+		}
+		if label(s, "__name__") == "sentinel_cpu_usage_percent" {
+			cpu = &series[i]
+		}
+	}
+
+	if cpu == nil {
+		t.Fatal("no sentinel_cpu_usage_percent series")
+	}
+	if got := label(*cpu, "agent_id"); got != "agent-1" {
+		t.Errorf("agent_id = %q, want agent-1", got)
+	}
+	if got := label(*cpu, "hostname"); got != "web-1" {
+		t.Errorf("hostname = %q, want web-1", got)
+	}
+	if got := cpu.Samples[0].Value; got != 42.5 {
+		t.Errorf("cpu value = %v, want 42.5", got)
+	}
+}
+
+func TestWriterAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{"none", Config{}, ""},
+		{"basic", Config{Username: "user", Password: "pass"}, "Basic dXNlcjpwYXNz"},
+		{"bearer", Config{BearerToken: "secret"}, "Bearer secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t)
+			tt.config.URL = r.URL
+			send(t, tt.config)
+
+			requests := r.received()
+			if len(requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(requests))
+			}
+			if got := requests[0].header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterRejectsBasicAndBearer(t *testing.T) {
+	_, err := NewWriter(Config{URL: "http://localhost", Username: "user", BearerToken: "secret"})
+	if err == nil {
+		t.Fatal("NewWriter accepted basic auth and a bearer token together")
+	}
+}
+
+func TestWriterRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		stats    Stats
+	}{
+		{
+			name:     "server errors are retried",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError},
+			requests: 3,
+			stats:    Stats{SentBatches: 1, Retries: 2},
+		},
+		{
+			name:     "too many requests is retried",
+			statuses: []int{http.StatusTooManyRequests},
+			requests: 2,
+			stats:    Stats{SentBatches: 1, Retries: 1},
+		},
+		{
+			name:     "client errors are not retried",
+			statuses: []int{http.StatusBadRequest},
+			requests: 1,
+			stats:    Stats{FailedBatches: 1},
+		},
+		{
+			name:     "retries are bounded",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			requests: 3,
+			stats:    Stats{FailedBatches: 1, Retries: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			w := send(t, Config{URL: r.URL, MaxRetries: 2})
+
+			if got := len(r.received()); got != tt.requests {
+				t.Errorf("got %d requests, want %d", got, tt.requests)
+			}
+			stats := w.Stats()
+			if stats.SentBatches != tt.stats.SentBatches || stats.FailedBatches != tt.stats.FailedBatches || stats.Retries != tt.stats.Retries {
+				t.Errorf("stats: sent %d, failed %d, retries %d; want sent %d, failed %d, retries %d",
+					stats.SentBatches, stats.FailedBatches, stats.Retries,
+					tt.stats.SentBatches, tt.stats.FailedBatches, tt.stats.Retries)
+			}
+			if tt.stats.FailedBatches > 0 && stats.LastError == "" {
+				t.Error("last error not recorded")
+			}
+		})
+	}
+}
+
+func TestWriterStopGivesUp(t *testing.T) {
+	statuses := make([]int, 10)
+	for i := range statuses {
+		statuses[i] = http.StatusServiceUnavailable
+	}
+	r := newReceiver(t, statuses...)
+	w, err := NewWriter(Config{URL: r.URL, BatchSize: 5, MaxRetries: 5, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	w.Start()
+	queueSample(t, w)
+	queueSample(t, w)
+
+	start := time.Now()
+	w.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stop took %v", elapsed)
+	}
+	// A batch already being retried when Stop came gets one last attempt;
+	// the rest of the queue is dropped after the first failure
+	if got := len(r.received()); got > 2 {
+		t.Errorf("got %d requests, want at most 2", got)
+	}
+	stats := w.Stats()
+	if stats.Pending != 0 || stats.SentBatches != 0 || stats.FailedBatches == 0 {
+		t.Errorf("stats: pending %d, sent %d, failed %d; want everything dropped",
+			stats.Pending, stats.SentBatches, stats.FailedBatches)
+	}
+}
+
+func label(s TimeSeries, name string) string {
+	for _, l := range s.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
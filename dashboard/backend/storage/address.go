@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+// Address family preferences for reaching an agent
+const (
+	FamilyAuto = "auto" // Prefer IPv4, fall back to IPv6
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// Schemes agents are reached with
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// SchemeFor returns the scheme of an agent that does or doesn't serve TLS
+func SchemeFor(tls bool) string {
+	if tls {
+		return SchemeHTTPS
+	}
+	return SchemeHTTP
+}
+
+// BaseURL builds the agent URL, bracketing IPv6 literals. An empty scheme
+// means http.
+func BaseURL(scheme, host string, port int) string {
+	if scheme == "" {
+		scheme = SchemeHTTP
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	return u.String()
+}
+
+// BaseURL returns the URL of the agent's active address
+func (a *Agent) BaseURL() string {
+	return BaseURL(a.Scheme, a.IPAddress, a.Port)
+}
+
+// Authorize adds the connection's bearer token, if any, to req
+func (c Connection) Authorize(req *http.Request) {
+	if c.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	}
+}
+
+// ValidFamily reports whether family is a known address family preference
+func ValidFamily(family string) bool {
+	switch family {
+	case "", FamilyAuto, FamilyIPv4, FamilyIPv6:
+		return true
+	}
+	return false
+}
+
+// SortAddresses orders IP addresses by the family preference, keeping the
+// original order within each family and the zone of link-local IPv6
+// addresses (fe80::1%eth0). Entries that aren't IP literals are dropped;
+// resolve hostnames first.
+func SortAddresses(addresses []string, family string) []string {
+	var v4, v6 []string
+	for _, addr := range addresses {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			continue
+		}
+		ip = ip.Unmap()
+		if ip.Is4() {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
+		}
+	}
+
+	switch family {
+	case FamilyIPv4:
+		return v4
+	case FamilyIPv6:
+		return v6
+	default:
+		return append(v4, v6...)
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"time"
+)
+
+// Availability summarizes how long an agent was up over a window
+type Availability struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	UpSeconds   float64   `json:"up_seconds"`
+	DownSeconds float64   `json:"down_seconds"`
+	// Percent of the time with a known status that the agent was up; nil
+	// when nothing is known about the window
+	Percent *float64 `json:"percent"`
+}
+
+// StatusUp reports whether a status counts as available. Degraded agents
+// still answer; stale ones have already missed polls.
+func StatusUp(status string) bool {
+	return status == StatusOnline || status == StatusDegraded
+}
+
+// statusKnown reports whether a status counts towards availability at all;
+// unknown and paused periods are left out
+func statusKnown(status string) bool {
+	switch status {
+	case StatusOnline, StatusDegraded, StatusStale, StatusOffline:
+		return true
+	}
+	return false
+}
+
+// ComputeAvailability walks the status history of [from, to]. initial is the
+// status in effect at from ("" if unknown) and events the changes within
+// the window, oldest first.
+func ComputeAvailability(initial string, events []StatusEvent, from, to time.Time) Availability {
+	result := Availability{From: from, To: to}
+
+	status := initial
+	since := from
+	account := func(until time.Time) {
+		if !statusKnown(status) || !until.After(since) {
+			return
+		}
+		seconds := until.Sub(since).Seconds()
+		if StatusUp(status) {
+			result.UpSeconds += seconds
+		} else {
+			result.DownSeconds += seconds
+		}
+	}
+
+	for _, event := range events {
+		if event.Time.Before(from) || event.Time.After(to) {
+			continue
+		}
+		account(event.Time)
+		status = event.To
+		since = event.Time
+	}
+	account(to)
+
+	if total := result.UpSeconds + result.DownSeconds; total > 0 {
+		percent := result.UpSeconds / total * 100
+		result.Percent = &percent
+	}
+	return result
+}
+
+// AgentAvailability computes the availability of one agent from the store
+func AgentAvailability(store Store, id string, from, to time.Time) (Availability, error) {
+	initial, err := store.StatusAt(id, from)
+	if err != nil {
+		return Availability{}, err
+	}
+	events, _, err := store.StatusEvents(id, from, to, "", 0)
+	if err != nil {
+		return Availability{}, err
+	}
+	return ComputeAvailability(initial, events, from, to), nil
+}
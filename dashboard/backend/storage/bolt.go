@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMeta   = []byte("meta")
+	bucketAgents = []byte("agents")
+	// One nested bucket per agent, keyed by event time
+	bucketEvents = []byte("events")
+	bucketGroups = []byte("groups")
+)
+
+// BoltStore keeps agents in an embedded bbolt database. Every change is a
+// transaction, so a crash never leaves a half-written file behind.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	s := &BoltStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) AddAgent(agent *Agent) error {
+	// Status and LastSeen are left to the first poll
+	agent.AddedAt = time.Now()
+	agent.LastSeen = time.Time{}
+	agent.Status = StatusUnknown
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketAgents).Get([]byte(agent.ID)) != nil {
+			return fmt.Errorf("%w: %s", ErrAgentExists, agent.ID)
+		}
+
+		event := StatusEvent{Time: agent.AddedAt, To: agent.Status, Reason: ReasonRegistered}
+		if err := putEvent(tx, agent.ID, event); err != nil {
+			return err
+		}
+		return putAgent(tx, agent)
+	})
+}
+
+func (s *BoltStore) GetAgent(id string) (*Agent, bool) {
+	var agent *Agent
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		agent, err = getAgent(tx, id)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to read agent %s: %v", id, err)
+		return nil, false
+	}
+	return agent, agent != nil
+}
+
+func (s *BoltStore) GetAllAgents() []*Agent {
+	agents := make([]*Agent, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAgents).ForEach(func(k, v []byte) error {
+			agent, err := decodeAgent(v)
+			if err != nil {
+				return fmt.Errorf("agent %s: %w", k, err)
+			}
+			agents = append(agents, agent)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to read agents: %v", err)
+	}
+	return agents
+}
+
+func (s *BoltStore) RemoveAgent(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		agents := tx.Bucket(bucketAgents)
+		if agents.Get([]byte(id)) == nil {
+			return ErrAgentNotFound
+		}
+		if err := agents.Delete([]byte(id)); err != nil {
+			return err
+		}
+		events := tx.Bucket(bucketEvents)
+		if events.Bucket([]byte(id)) != nil {
+			return events.DeleteBucket([]byte(id))
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) UpdateAgentStatus(id string, update StatusUpdate) error {
+	return s.updateAgent(id, func(tx *bolt.Tx, agent *Agent) error {
+		// A poll already in flight when the agent was paused must not
+		// resume it
+		if agent.Status == StatusPaused {
+			return nil
+		}
+
+		now := time.Now()
+		// Agents imported without history get a starting event
+		noHistory := tx.Bucket(bucketEvents).Bucket([]byte(id)) == nil
+		if agent.Status != update.Status || noHistory {
+			event := StatusEvent{Time: now, From: agent.Status, To: update.Status, Reason: update.Reason}
+			if err := putEvent(tx, id, event); err != nil {
+				return err
+			}
+		}
+		agent.Status = update.Status
+		agent.Failures = update.Failures
+		agent.NextPoll = &update.NextPoll
+		if update.Seen {
+			agent.LastSeen = now
+			agent.ClockSkewMS = update.ClockSkew.Milliseconds()
+		}
+		if update.SchemaVersion != 0 {
+			agent.SchemaVersion = update.SchemaVersion
+			agent.SchemaWarning = update.SchemaWarning
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) SetPaused(id string, paused bool) (*Agent, error) {
+	var updated *Agent
+	err := s.updateAgent(id, func(tx *bolt.Tx, agent *Agent) error {
+		updated = agent
+		if paused == (agent.Status == StatusPaused) {
+			return nil
+		}
+
+		event := StatusEvent{Time: time.Now(), From: agent.Status, To: StatusPaused, Reason: ReasonPaused}
+		if !paused {
+			// Nothing is known until the next poll
+			event.To = StatusUnknown
+			event.Reason = ReasonResumed
+		}
+		agent.Status = event.To
+		agent.Failures = 0
+		agent.NextPoll = nil
+		return putEvent(tx, id, event)
+	})
+	return updated, err
+}
+
+func (s *BoltStore) UpdateAgentAddress(id string, ipAddress string, addresses []string) error {
+	return s.updateAgent(id, func(tx *bolt.Tx, agent *Agent) error {
+		agent.IPAddress = ipAddress
+		agent.Addresses = addresses
+		return nil
+	})
+}
+
+func (s *BoltStore) UpdateAgentHealth(id string, health *AgentHealth) error {
+	return s.updateAgent(id, func(tx *bolt.Tx, agent *Agent) error {
+		agent.AgentHealth = health
+		return nil
+	})
+}
+
+func (s *BoltStore) UpdateAgentMetadata(id string, update func(meta *AgentMetadata)) (*Agent, error) {
+	var updated *Agent
+	err := s.updateAgent(id, func(tx *bolt.Tx, agent *Agent) error {
+		update(&agent.AgentMetadata)
+		updated = agent
+		return nil
+	})
+	return updated, err
+}
+
+func (s *BoltStore) UpdateAgentSettings(id string, update func(conn *Connection, meta *AgentMetadata)) (*Agent, error) {
+	var updated *Agent
+	err := s.updateAgent(id, func(tx *bolt.Tx, agent *Agent) error {
+		update(&agent.Connection, &agent.AgentMetadata)
+		updated = agent
+		return nil
+	})
+	return updated, err
+}
+
+func (s *BoltStore) StatusEvents(id string, from, to time.Time, cursor string, limit int) ([]StatusEvent, string, error) {
+	start := eventKey(from, 0)
+	var after []byte
+	if cursor != "" {
+		// The cursor is the key of the last event returned; events sharing
+		// its timestamp are told apart by their sequence number
+		key, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(key) != 16 {
+			return nil, "", ErrInvalidCursor
+		}
+		if bytes.Compare(key, start) >= 0 {
+			start, after = key, key
+		}
+	}
+
+	events := make([]StatusEvent, 0)
+	var next string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketEvents).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		var last []byte
+		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+			if bytes.Equal(k, after) {
+				continue
+			}
+			var event StatusEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if event.Time.After(to) {
+				break
+			}
+			if limit > 0 && len(events) == limit {
+				next = base64.RawURLEncoding.EncodeToString(last)
+				break
+			}
+			events = append(events, event)
+			last = k
+		}
+		return nil
+	})
+	return events, next, err
+}
+
+func (s *BoltStore) StatusAt(id string, t time.Time) (string, error) {
+	var status string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketEvents).Bucket([]byte(id))
+		if bucket == nil {
+			return nil
+		}
+
+		// Last event strictly before t
+		c := bucket.Cursor()
+		k, _ := c.Seek(eventKey(t, 0))
+		var v []byte
+		if k == nil {
+			_, v = c.Last()
+		} else {
+			_, v = c.Prev()
+		}
+		if v == nil {
+			return nil
+		}
+
+		var event StatusEvent
+		if err := json.Unmarshal(v, &event); err != nil {
+			return err
+		}
+		status = event.To
+		return nil
+	})
+	return status, err
+}
+
+func (s *BoltStore) GetGroup(name string) (*Group, bool) {
+	var group *Group
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketGroups).Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		group = &Group{}
+		return json.Unmarshal(data, group)
+	})
+	if err != nil {
+		log.Printf("Failed to read group %s: %v", name, err)
+		return nil, false
+	}
+	return group, group != nil
+}
+
+func (s *BoltStore) GetAllGroups() []*Group {
+	groups := make([]*Group, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGroups).ForEach(func(k, v []byte) error {
+			group := &Group{}
+			if err := json.Unmarshal(v, group); err != nil {
+				return fmt.Errorf("group %s: %w", k, err)
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to read groups: %v", err)
+	}
+	return groups
+}
+
+func (s *BoltStore) PutGroup(group *Group) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGroups).Put([]byte(group.Name), data)
+	})
+}
+
+func (s *BoltStore) RemoveGroup(name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGroups).Delete([]byte(name))
+	})
+}
+
+// updateAgent loads, modifies and saves one agent in a single transaction
+func (s *BoltStore) updateAgent(id string, update func(tx *bolt.Tx, agent *Agent) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		agent, err := getAgent(tx, id)
+		if err != nil {
+			return err
+		}
+		if agent == nil {
+			return fmt.Errorf("%w: %s", ErrAgentNotFound, id)
+		}
+		if err := update(tx, agent); err != nil {
+			return err
+		}
+		return putAgent(tx, agent)
+	})
+}
+
+func getAgent(tx *bolt.Tx, id string) (*Agent, error) {
+	data := tx.Bucket(bucketAgents).Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+
+	return decodeAgent(data)
+}
+
+func putAgent(tx *bolt.Tx, agent *Agent) error {
+	agent.AuthTokenSet = agent.AuthToken != ""
+	data, err := json.Marshal(agentRecord{Agent: agent, AuthToken: agent.AuthToken})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketAgents).Put([]byte(agent.ID), data)
+}
+
+// agentRecord is how an agent is stored: its API form plus the token the
+// API never returns
+type agentRecord struct {
+	*Agent
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+func decodeAgent(data []byte) (*Agent, error) {
+	record := agentRecord{Agent: &Agent{}}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	record.Agent.AuthToken = record.AuthToken
+	record.Agent.AuthTokenSet = record.AuthToken != ""
+	return record.Agent, nil
+}
+
+func putEvent(tx *bolt.Tx, id string, event StatusEvent) error {
+	bucket, err := tx.Bucket(bucketEvents).CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// The sequence keeps events recorded in the same nanosecond apart
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	return bucket.Put(eventKey(event.Time, seq), data)
+}
+
+// eventKey sorts events by time: big-endian nanoseconds, then a sequence
+func eventKey(t time.Time, seq uint64) []byte {
+	var nanos uint64
+	if t.After(time.Unix(0, 0)) {
+		nanos = uint64(t.UnixNano())
+	}
+
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, nanos)
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
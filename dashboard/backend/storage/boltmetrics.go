@@ -0,0 +1,587 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucketSeries holds one nested bucket per agent, and in it one bucket per
+// series keyed by point time. Rollup tiers have the same layout in their
+// own bucket (see tierBucket), and bucketRollups records how far each was
+// rolled up.
+var (
+	bucketSeries  = []byte("series")
+	bucketRollups = []byte("rollups")
+)
+
+// BoltMetrics is the embedded time-series store: points live in a bbolt
+// file next to the dashboard, so no database server is needed. Each series
+// (agent, measurement, field and the measurement's tags) is a bucket of
+// big-endian nanosecond timestamps mapping to float64 values, or to the
+// encoded rollup of the window starting then.
+type BoltMetrics struct {
+	db    *bolt.DB
+	tiers Tiers
+}
+
+func NewBoltMetrics(path string, tiers Tiers) (*BoltMetrics, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metrics database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(bucketRollups); err != nil {
+			return err
+		}
+		for _, tier := range append(Tiers{RawTier}, tiers...) {
+			if _, err := tx.CreateBucketIfNotExists(tierBucket(tier)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltMetrics{db: db, tiers: tiers}, nil
+}
+
+// point is one value of a series
+type point struct {
+	measurement string
+	field       string
+	tags        map[string]string
+	value       float64
+}
+
+// WriteMetrics stores the same measurements and fields as InfluxDB
+func (m *BoltMetrics) WriteMetrics(agentID string, metrics *SystemMetrics) error {
+	timestamp := metrics.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	points := []point{
+		{"cpu", "usage_percent", nil, metrics.CPUPercent},
+		{"cpu", "core_count", nil, float64(metrics.CoreCount)},
+		{"memory", "total", nil, float64(metrics.MemTotal)},
+		{"memory", "used", nil, float64(metrics.MemUsed)},
+		{"memory", "available", nil, float64(metrics.MemAvailable)},
+		{"memory", "used_percent", nil, metrics.MemPercent},
+		{"system", "uptime", nil, float64(metrics.Uptime)},
+	}
+	if len(metrics.LoadAvg) == 3 {
+		points = append(points,
+			point{"system", "load1", nil, metrics.LoadAvg[0]},
+			point{"system", "load5", nil, metrics.LoadAvg[1]},
+			point{"system", "load15", nil, metrics.LoadAvg[2]},
+		)
+	}
+	for _, disk := range metrics.Disks {
+		tags := map[string]string{"mount_point": disk.MountPoint, "device": disk.Device, "fs_type": disk.FSType}
+		points = append(points,
+			point{"disk", "total", tags, float64(disk.Total)},
+			point{"disk", "used", tags, float64(disk.Used)},
+			point{"disk", "free", tags, float64(disk.Free)},
+			point{"disk", "used_percent", tags, disk.UsedPercent},
+		)
+	}
+	for _, net := range metrics.Networks {
+		tags := map[string]string{"interface": net.Interface}
+		points = append(points,
+			point{"network", "bytes_sent", tags, float64(net.BytesSent)},
+			point{"network", "bytes_recv", tags, float64(net.BytesRecv)},
+			point{"network", "packets_sent", tags, float64(net.PacketsSent)},
+			point{"network", "packets_recv", tags, float64(net.PacketsRecv)},
+		)
+	}
+
+	key := timeKey(timestamp)
+	return m.db.Update(func(tx *bolt.Tx) error {
+		agent, err := tx.Bucket(bucketSeries).CreateBucketIfNotExists([]byte(agentID))
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			series, err := agent.CreateBucketIfNotExists(seriesKey(p.measurement, p.field, p.tags))
+			if err != nil {
+				return err
+			}
+			// Points are appended in time order
+			series.FillPercent = 0.9
+			if err := series.Put(key, floatValue(p.value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// QueryMetrics aggregates the matching series into windows the way
+// InfluxDB's aggregateWindow does: windows are aligned to the epoch, empty
+// ones are left out and each point is stamped with its window's end.
+func (m *BoltMetrics) QueryMetrics(q HistoryQuery) (*HistoryResult, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	tier := m.tiers.ForStart(q.Start, time.Now())
+	q.useTier(tier)
+
+	history := &HistoryResult{
+		Measurement: q.Measurement,
+		Start:       q.Start,
+		End:         q.End,
+		Window:      q.Window,
+		Aggregate:   q.Aggregate,
+		Tier:        tier.Name,
+		Series:      make([]HistorySeries, 0),
+	}
+	if len(q.AgentIDs) == 1 {
+		history.AgentID = q.AgentIDs[0]
+	}
+
+	err := m.db.View(func(tx *bolt.Tx) error {
+		points := tx.Bucket(tierBucket(tier))
+		if points == nil {
+			return nil
+		}
+		for _, agentID := range q.AgentIDs {
+			agent := points.Bucket([]byte(agentID))
+			if agent == nil {
+				continue
+			}
+
+			var matched []HistorySeries
+			err := agent.ForEachBucket(func(k []byte) error {
+				measurement, field, tags := parseSeriesKey(k)
+				if !q.matches(measurement, field, tags) {
+					return nil
+				}
+				series := HistorySeries{AgentID: agentID, Field: field}
+				if len(tags) > 0 {
+					series.Tags = tags
+				}
+				aggregateSeries(agent.Bucket(k), q, tier.Rollup(), &series)
+				if len(series.Times) > 0 {
+					matched = append(matched, series)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			history.Series = append(history.Series, matched...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// matches reports whether a series belongs to the query
+func (q *HistoryQuery) matches(measurement, field string, tags map[string]string) bool {
+	if measurement != q.Measurement {
+		return false
+	}
+	if len(q.Fields) > 0 && !contains(q.Fields, field) {
+		return false
+	}
+	for tag, values := range q.Tags {
+		if len(values) > 0 && !contains(values, tags[tag]) {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregateSeries reads the points of [q.Start, q.End) and appends one
+// aggregated point per non-empty window to series. With rollups, the
+// window combines the rollups that start in it.
+func aggregateSeries(bucket *bolt.Bucket, q HistoryQuery, rollups bool, series *HistorySeries) {
+	window := q.Window.Nanoseconds()
+	end := q.End.UnixNano()
+	stat := rollupStat(q.Aggregate)
+
+	var values, weights []float64
+	var windowStart int64
+	flush := func() {
+		if len(values) == 0 {
+			return
+		}
+		stop := min(windowStart+window, end)
+		series.Times = append(series.Times, time.Unix(0, stop).UnixMilli())
+		if rollups {
+			series.Values = append(series.Values, combineRollups(stat, values, weights))
+		} else {
+			series.Values = append(series.Values, aggregate(stat, values))
+		}
+		values, weights = values[:0], weights[:0]
+	}
+
+	c := bucket.Cursor()
+	for k, v := c.Seek(timeKey(q.Start)); k != nil; k, v = c.Next() {
+		t := int64(binary.BigEndian.Uint64(k))
+		if t >= end {
+			break
+		}
+		if start := t - t%window; start != windowStart {
+			flush()
+			windowStart = start
+		}
+		if rollups {
+			r := decodeRollup(v)
+			values = append(values, r.stat(stat))
+			weights = append(weights, r.count)
+		} else {
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		}
+	}
+	flush()
+
+	if q.Aggregate == RateAggregate {
+		toRates(series)
+	}
+}
+
+// toRates turns the per-window last values of a counter into per-second
+// rates, like Flux's derivative(nonNegative: true): the first point has no
+// rate and a drop means the counter was reset, so it counts from zero
+func toRates(series *HistorySeries) {
+	if len(series.Times) < 2 {
+		series.Times, series.Values = series.Times[:0], series.Values[:0]
+		return
+	}
+	rates := make([]float64, 0, len(series.Values)-1)
+	for i := 1; i < len(series.Values); i++ {
+		elapsed := float64(series.Times[i]-series.Times[i-1]) / 1000
+		delta := series.Values[i] - series.Values[i-1]
+		if delta < 0 {
+			delta = series.Values[i]
+		}
+		rates = append(rates, delta/elapsed)
+	}
+	series.Times, series.Values = series.Times[1:], rates
+}
+
+// combineRollups merges the stat of several rollups with the same
+// aggregate: the mean of means weighted by their number of points, the min
+// of minimums and so on. Percentiles of percentiles are an approximation;
+// they are exact when the query window equals the tier's resolution.
+func combineRollups(function string, values, weights []float64) float64 {
+	if function != "mean" {
+		return aggregate(function, values)
+	}
+	var sum, count float64
+	for i, v := range values {
+		sum += v * weights[i]
+		count += weights[i]
+	}
+	if count == 0 {
+		return aggregate("mean", values)
+	}
+	return sum / count
+}
+
+// aggregate reduces the values of one window
+func aggregate(function string, values []float64) float64 {
+	switch function {
+	case "min":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Min(result, v)
+		}
+		return result
+	case "max":
+		result := values[0]
+		for _, v := range values[1:] {
+			result = math.Max(result, v)
+		}
+		return result
+	case "last":
+		return values[len(values)-1]
+	case "p95":
+		return quantile(values, 0.95)
+	case "p99":
+		return quantile(values, 0.99)
+	}
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// quantile interpolates between the closest ranks; values are reordered
+func quantile(values []float64, q float64) float64 {
+	sort.Float64s(values)
+	rank := q * float64(len(values)-1)
+	lower := int(rank)
+	if lower+1 >= len(values) {
+		return values[lower]
+	}
+	return values[lower] + (values[lower+1]-values[lower])*(rank-float64(lower))
+}
+
+// rolledUpSeries holds the rollups of one series until they are written
+type rolledUpSeries struct {
+	agentID, key []byte
+	times        []int64
+	rollups      []rollup
+}
+
+// Rollup summarizes the raw points of [from, to) into tier, one rollup per
+// window keyed by the window's start. The raw points are read in a read
+// transaction and only the rollups are written under the writer lock, so
+// WriteMetrics isn't held up by the scan.
+func (m *BoltMetrics) Rollup(tier Tier, from, to time.Time) error {
+	resolution := tier.Resolution.Nanoseconds()
+	end := to.UnixNano()
+
+	var rolledUp []*rolledUpSeries
+	err := m.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketSeries)
+		return raw.ForEachBucket(func(agentID []byte) error {
+			agent := raw.Bucket(agentID)
+			return agent.ForEachBucket(func(k []byte) error {
+				// Keys are only valid during the transaction
+				series := &rolledUpSeries{agentID: bytes.Clone(agentID), key: bytes.Clone(k)}
+				var values []float64
+				var windowStart int64
+				flush := func() {
+					if len(values) > 0 {
+						series.times = append(series.times, windowStart)
+						series.rollups = append(series.rollups, summarize(values))
+						values = values[:0]
+					}
+				}
+
+				c := agent.Bucket(k).Cursor()
+				for key, v := c.Seek(timeKey(from)); key != nil; key, v = c.Next() {
+					t := int64(binary.BigEndian.Uint64(key))
+					if t >= end {
+						break
+					}
+					if start := t - t%resolution; start != windowStart {
+						flush()
+						windowStart = start
+					}
+					values = append(values, math.Float64frombits(binary.BigEndian.Uint64(v)))
+				}
+				flush()
+				if len(series.rollups) > 0 {
+					rolledUp = append(rolledUp, series)
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.db.Update(func(tx *bolt.Tx) error {
+		dest, err := tx.CreateBucketIfNotExists(tierBucket(tier))
+		if err != nil {
+			return err
+		}
+		for _, series := range rolledUp {
+			destAgent, err := dest.CreateBucketIfNotExists(series.agentID)
+			if err != nil {
+				return err
+			}
+			out, err := destAgent.CreateBucketIfNotExists(series.key)
+			if err != nil {
+				return err
+			}
+			out.FillPercent = 0.9
+			for i, r := range series.rollups {
+				if err := out.Put(timeKey(time.Unix(0, series.times[i])), r.encode()); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(bucketRollups).Put([]byte(tier.Name), timeKey(to))
+	})
+}
+
+// RolledUpTo returns the end of the last rollup into tier
+func (m *BoltMetrics) RolledUpTo(tier Tier) (time.Time, error) {
+	var to time.Time
+	err := m.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketRollups).Get([]byte(tier.Name)); len(v) == 8 {
+			to = time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+		}
+		return nil
+	})
+	return to, err
+}
+
+// Prune deletes every point of tier older than before, and series left
+// empty
+func (m *BoltMetrics) Prune(tier Tier, before time.Time) error {
+	limit := timeKey(before)
+	return m.db.Update(func(tx *bolt.Tx) error {
+		points := tx.Bucket(tierBucket(tier))
+		if points == nil {
+			return nil
+		}
+		return points.ForEachBucket(func(agentID []byte) error {
+			agent := points.Bucket(agentID)
+
+			var empty [][]byte
+			err := agent.ForEachBucket(func(k []byte) error {
+				series := agent.Bucket(k)
+				c := series.Cursor()
+				for key, _ := c.First(); key != nil && bytes.Compare(key, limit) < 0; key, _ = c.First() {
+					if err := c.Delete(); err != nil {
+						return err
+					}
+				}
+				if key, _ := c.First(); key == nil {
+					empty = append(empty, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range empty {
+				if err := agent.DeleteBucket(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+func (m *BoltMetrics) Close() error {
+	return m.db.Close()
+}
+
+// tierBucket names the bucket holding a tier's series
+func tierBucket(tier Tier) []byte {
+	if !tier.Rollup() {
+		return bucketSeries
+	}
+	return []byte("rollup_" + tier.Name)
+}
+
+// rollup summarizes the points of one window
+type rollup struct {
+	count, mean, min, max, last, p95, p99 float64
+}
+
+// summarize rolls values up; they are reordered
+func summarize(values []float64) rollup {
+	r := rollup{
+		count: float64(len(values)),
+		mean:  aggregate("mean", values),
+		min:   aggregate("min", values),
+		max:   aggregate("max", values),
+		last:  values[len(values)-1],
+	}
+	r.p95 = quantile(values, 0.95)
+	r.p99 = quantile(values, 0.99)
+	return r
+}
+
+// stat returns the value kept for an aggregate
+func (r rollup) stat(aggregate string) float64 {
+	switch aggregate {
+	case "min":
+		return r.min
+	case "max":
+		return r.max
+	case "last":
+		return r.last
+	case "p95":
+		return r.p95
+	case "p99":
+		return r.p99
+	}
+	return r.mean
+}
+
+func (r rollup) encode() []byte {
+	value := make([]byte, 0, 7*8)
+	for _, v := range []float64{r.count, r.mean, r.min, r.max, r.last, r.p95, r.p99} {
+		value = binary.BigEndian.AppendUint64(value, math.Float64bits(v))
+	}
+	return value
+}
+
+func decodeRollup(value []byte) rollup {
+	var fields [7]float64
+	for i := range fields {
+		if len(value) >= (i+1)*8 {
+			fields[i] = math.Float64frombits(binary.BigEndian.Uint64(value[i*8:]))
+		}
+	}
+	return rollup{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]}
+}
+
+// seriesKey joins measurement, field and sorted tag=value pairs with NUL
+// bytes, which can't appear in any of them
+func seriesKey(measurement, field string, tags map[string]string) []byte {
+	parts := []string{measurement, field}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, name+"="+tags[name])
+	}
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+func parseSeriesKey(key []byte) (string, string, map[string]string) {
+	parts := strings.Split(string(key), "\x00")
+	if len(parts) < 2 {
+		return "", "", nil
+	}
+	var tags map[string]string
+	for _, pair := range parts[2:] {
+		name, value, _ := strings.Cut(pair, "=")
+		if value == "" {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[name] = value
+	}
+	return parts[0], parts[1], tags
+}
+
+// timeKey encodes t as big-endian nanoseconds so keys sort by time
+func timeKey(t time.Time) []byte {
+	var nanos uint64
+	if t.After(time.Unix(0, 0)) {
+		nanos = uint64(t.UnixNano())
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, nanos)
+	return key
+}
+
+func floatValue(v float64) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, math.Float64bits(v))
+	return value
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FleetFunctions combine the series of every selected agent per point
+var FleetFunctions = []string{"min", "max", "mean", "sum"}
+
+// FleetHistory holds the series of several agents on one shared time axis.
+// Values[i] of every series belongs to Times[i]; null where the series has
+// no point at that time.
+type FleetHistory struct {
+	Measurement string          `json:"measurement"`
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	Window      time.Duration   `json:"window_ns"`
+	Aggregate   string          `json:"aggregate"`
+	Tier        string          `json:"tier"`
+	Times       []int64         `json:"times"` // Unix milliseconds, ascending
+	Agents      []FleetAgent    `json:"agents"`
+	Fleet       []AlignedSeries `json:"fleet"`
+}
+
+type FleetAgent struct {
+	AgentID string          `json:"agent_id"`
+	Name    string          `json:"name"`
+	Series  []AlignedSeries `json:"series"`
+}
+
+type AlignedSeries struct {
+	Field    string            `json:"field"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Function string            `json:"function,omitempty"` // Fleet function of fleet series
+	Values   []*float64        `json:"values"`
+}
+
+// ValidateFleetFunctions checks names against FleetFunctions
+func ValidateFleetFunctions(functions []string) error {
+	for _, function := range functions {
+		if !contains(FleetFunctions, function) {
+			return fmt.Errorf("%w: unknown fleet function %q (known: %s)", ErrInvalidQuery, function, strings.Join(FleetFunctions, ", "))
+		}
+	}
+	return nil
+}
+
+// AlignHistory puts the series of a multi-agent result on the union of
+// their times and adds, per field, one fleet series for each function.
+// Fleet series combine every agent and tag (e.g. all disks of all agents).
+// names maps agent IDs to display names; agents keep the order of ids.
+func AlignHistory(result *HistoryResult, ids []string, names map[string]string, functions []string) *FleetHistory {
+	fleet := &FleetHistory{
+		Measurement: result.Measurement,
+		Start:       result.Start,
+		End:         result.End,
+		Window:      result.Window,
+		Aggregate:   result.Aggregate,
+		Tier:        result.Tier,
+		Times:       make([]int64, 0),
+		Agents:      make([]FleetAgent, 0, len(ids)),
+		Fleet:       make([]AlignedSeries, 0),
+	}
+
+	// Shared time axis
+	seen := make(map[int64]bool)
+	for _, series := range result.Series {
+		for _, t := range series.Times {
+			if !seen[t] {
+				seen[t] = true
+				fleet.Times = append(fleet.Times, t)
+			}
+		}
+	}
+	sort.Slice(fleet.Times, func(i, j int) bool { return fleet.Times[i] < fleet.Times[j] })
+	index := make(map[int64]int, len(fleet.Times))
+	for i, t := range fleet.Times {
+		index[t] = i
+	}
+
+	agents := make(map[string]*FleetAgent, len(ids))
+	for _, id := range ids {
+		fleet.Agents = append(fleet.Agents, FleetAgent{AgentID: id, Name: names[id], Series: make([]AlignedSeries, 0)})
+	}
+	for i := range fleet.Agents {
+		agents[fleet.Agents[i].AgentID] = &fleet.Agents[i]
+	}
+
+	byField := make(map[string][]AlignedSeries)
+	var fields []string
+	for _, series := range result.Series {
+		aligned := AlignedSeries{Field: series.Field, Tags: series.Tags, Values: make([]*float64, len(fleet.Times))}
+		for i, t := range series.Times {
+			value := series.Values[i]
+			aligned.Values[index[t]] = &value
+		}
+		if agent, ok := agents[series.AgentID]; ok {
+			agent.Series = append(agent.Series, aligned)
+		}
+		if _, ok := byField[series.Field]; !ok {
+			fields = append(fields, series.Field)
+		}
+		byField[series.Field] = append(byField[series.Field], aligned)
+	}
+
+	counters := Measurements[result.Measurement].Counters
+	for _, field := range fields {
+		for _, function := range functions {
+			// Adding up lifetime counters means nothing; sum their rates
+			if function == "sum" && contains(counters, field) && result.Aggregate != RateAggregate {
+				continue
+			}
+			fleet.Fleet = append(fleet.Fleet, AlignedSeries{
+				Field:    field,
+				Function: function,
+				Values:   combine(byField[field], len(fleet.Times), function),
+			})
+		}
+	}
+	return fleet
+}
+
+// combine applies a fleet function across series at every point, skipping
+// series without a value there
+func combine(series []AlignedSeries, points int, function string) []*float64 {
+	values := make([]*float64, points)
+	for i := 0; i < points; i++ {
+		var result float64
+		n := 0
+		for _, s := range series {
+			v := s.Values[i]
+			if v == nil {
+				continue
+			}
+			switch {
+			case n == 0:
+				result = *v
+			case function == "min" && *v < result:
+				result = *v
+			case function == "max" && *v > result:
+				result = *v
+			case function == "sum" || function == "mean":
+				result += *v
+			}
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+		if function == "mean" {
+			result /= float64(n)
+		}
+		values[i] = &result
+	}
+	return values
+}
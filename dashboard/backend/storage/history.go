@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Measurement describes what WriteMetrics stores under one measurement
+type Measurement struct {
+	Fields []string
+	// Counters are the fields that only grow, until the agent restarts; the
+	// rate aggregate turns them into per-second rates
+	Counters []string
+	// Tags identify a series within the measurement and can be filtered on
+	Tags []string
+}
+
+// Measurements lists every measurement written by WriteMetrics. History
+// queries are checked against it before anything reaches the database.
+var Measurements = map[string]Measurement{
+	"cpu":     {Fields: []string{"usage_percent", "core_count"}},
+	"memory":  {Fields: []string{"total", "used", "available", "used_percent"}},
+	"disk":    {Fields: []string{"total", "used", "free", "used_percent"}, Tags: []string{"mount_point", "device", "fs_type"}},
+	"network": {Fields: networkCounters, Counters: networkCounters, Tags: []string{"interface"}},
+	"system":  {Fields: []string{"uptime", "load1", "load5", "load15"}},
+}
+
+// Every network field is a cumulative counter
+var networkCounters = []string{"bytes_sent", "bytes_recv", "packets_sent", "packets_recv"}
+
+// Aggregates applied to each window of a history query
+var Aggregates = []string{"mean", "min", "max", "last", "p95", "p99", RateAggregate}
+
+// RateAggregate is the per-second increase of a counter from one window's
+// last value to the next. A drop (counter reset) counts from zero, and the
+// first window has no rate.
+const RateAggregate = "rate"
+
+// RollupStats are the statistics rollup tiers keep for each window
+var RollupStats = []string{"mean", "min", "max", "last", "p95", "p99"}
+
+// rollupCount is the extra rollup statistic InfluxDB keeps: the number of
+// raw points in the window, which weighs the window's mean
+const rollupCount = "count"
+
+// rollupStat is the rollup statistic an aggregate is computed from
+func rollupStat(aggregate string) string {
+	if aggregate == RateAggregate {
+		return "last"
+	}
+	return aggregate
+}
+
+// Limits on the number of points per series and agents per query
+const (
+	DefaultHistoryPoints = 300
+	MaxHistoryPoints     = 5000
+	MaxHistoryAgents     = 500
+)
+
+// Errors for history queries the caller got wrong
+var (
+	ErrUnknownMeasurement = errors.New("unknown measurement")
+	ErrUnknownField       = errors.New("unknown field")
+	ErrInvalidQuery       = errors.New("invalid query")
+)
+
+// HistoryQuery selects stored metrics of one or more agents
+type HistoryQuery struct {
+	AgentIDs    []string
+	Measurement string
+	Fields      []string // Empty selects every field
+	// Tags keeps series whose tag matches one of the values
+	Tags  map[string][]string
+	Start time.Time
+	End   time.Time
+	// Window is the aggregation window; zero picks the smallest round
+	// window that keeps each series within MaxPoints
+	Window    time.Duration
+	MaxPoints int
+	Aggregate string // One of Aggregates, default mean
+}
+
+// HistoryResult holds the queried series in columns: the i-th time and
+// value of a series form one point
+type HistoryResult struct {
+	AgentID     string          `json:"agent_id,omitempty"` // Set when querying one agent
+	Measurement string          `json:"measurement"`
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	Window      time.Duration   `json:"window_ns"`
+	Aggregate   string          `json:"aggregate"`
+	Tier        string          `json:"tier"` // Tier the points were read from
+	Series      []HistorySeries `json:"series"`
+}
+
+type HistorySeries struct {
+	AgentID string            `json:"agent_id"`
+	Field   string            `json:"field"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Times   []int64           `json:"times"` // Unix milliseconds, ascending
+	Values  []float64         `json:"values"`
+}
+
+// Validate checks the query against the known measurements, fields and
+// tags and fills in defaults
+func (q *HistoryQuery) Validate() error {
+	m, ok := Measurements[q.Measurement]
+	if !ok {
+		return fmt.Errorf("%w %q (known: %s)", ErrUnknownMeasurement, q.Measurement, strings.Join(MeasurementNames(), ", "))
+	}
+	for _, field := range q.Fields {
+		if !contains(m.Fields, field) {
+			return fmt.Errorf("%w %q for %s (known: %s)", ErrUnknownField, field, q.Measurement, strings.Join(m.Fields, ", "))
+		}
+	}
+	for tag := range q.Tags {
+		if !contains(m.Tags, tag) {
+			return fmt.Errorf("%w: %s can't be filtered by %q", ErrInvalidQuery, q.Measurement, tag)
+		}
+	}
+	if len(q.AgentIDs) == 0 {
+		return fmt.Errorf("%w: agent ID required", ErrInvalidQuery)
+	}
+	if len(q.AgentIDs) > MaxHistoryAgents {
+		return fmt.Errorf("%w: at most %d agents per query", ErrInvalidQuery, MaxHistoryAgents)
+	}
+	for _, id := range q.AgentIDs {
+		if id == "" {
+			return fmt.Errorf("%w: empty agent ID", ErrInvalidQuery)
+		}
+	}
+
+	if q.Aggregate == "" {
+		q.Aggregate = "mean"
+	}
+	if !contains(Aggregates, q.Aggregate) {
+		return fmt.Errorf("%w: unknown aggregate %q (known: %s)", ErrInvalidQuery, q.Aggregate, strings.Join(Aggregates, ", "))
+	}
+
+	if !q.Start.Before(q.End) {
+		return fmt.Errorf("%w: start must be before end", ErrInvalidQuery)
+	}
+	if q.MaxPoints == 0 {
+		q.MaxPoints = DefaultHistoryPoints
+	}
+	if q.MaxPoints < 1 || q.MaxPoints > MaxHistoryPoints {
+		return fmt.Errorf("%w: points must be between 1 and %d", ErrInvalidQuery, MaxHistoryPoints)
+	}
+
+	span := q.End.Sub(q.Start)
+	switch {
+	case q.Window == 0:
+		q.Window = autoWindow(span, q.MaxPoints)
+	case q.Window < time.Second:
+		return fmt.Errorf("%w: window must be at least 1s", ErrInvalidQuery)
+	case span/q.Window > time.Duration(MaxHistoryPoints):
+		return fmt.Errorf("%w: window %v gives more than %d points over %v", ErrInvalidQuery, q.Window, MaxHistoryPoints, span)
+	}
+	return nil
+}
+
+// windowSteps are the round window sizes autoWindow picks from
+var windowSteps = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// autoWindow returns the smallest round window that splits span into at
+// most points windows; beyond a day it uses whole days
+func autoWindow(span time.Duration, points int) time.Duration {
+	need := (span + time.Duration(points) - 1) / time.Duration(points)
+	for _, step := range windowSteps {
+		if step >= need {
+			return step
+		}
+	}
+	day := 24 * time.Hour
+	return (need + day - 1) / day * day
+}
+
+// MeasurementNames returns the known measurements, sorted
+func MeasurementNames() []string {
+	names := make([]string, 0, len(Measurements))
+	for name := range Measurements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fluxQuery builds the Flux query for a validated HistoryQuery reading
+// bucket, which holds tier. Series are regrouped by agent, field and the
+// measurement's own tags, so points don't split when e.g. the hostname
+// changes.
+func (q *HistoryQuery) fluxQuery(bucket string, tier Tier) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "from(bucket: %s)\n", fluxString(bucket))
+	fmt.Fprintf(&b, "|> range(start: %s, stop: %s)\n", q.Start.UTC().Format(time.RFC3339Nano), q.End.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "|> filter(fn: (r) => r[\"_measurement\"] == %s)\n", fluxString(q.Measurement))
+	fmt.Fprintf(&b, "|> filter(fn: (r) => %s)\n", fluxAnyOf("agent_id", q.AgentIDs))
+	weighted := tier.Rollup() && q.Aggregate == "mean"
+	switch {
+	case weighted:
+		fmt.Fprintf(&b, "|> filter(fn: (r) => %s)\n", fluxAnyOf("stat", []string{"mean", rollupCount}))
+	case tier.Rollup():
+		// Rollups keep one series per stat, combined by the same aggregate
+		fmt.Fprintf(&b, "|> filter(fn: (r) => r[\"stat\"] == %s)\n", fluxString(rollupStat(q.Aggregate)))
+	}
+	if len(q.Fields) > 0 {
+		fmt.Fprintf(&b, "|> filter(fn: (r) => %s)\n", fluxAnyOf("_field", q.Fields))
+	}
+
+	tags := make([]string, 0, len(q.Tags))
+	for tag := range q.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if len(q.Tags[tag]) > 0 {
+			fmt.Fprintf(&b, "|> filter(fn: (r) => %s)\n", fluxAnyOf(tag, q.Tags[tag]))
+		}
+	}
+
+	columns := []string{fluxString("agent_id"), fluxString("_field")}
+	for _, tag := range Measurements[q.Measurement].Tags {
+		columns = append(columns, fluxString(tag))
+	}
+	fmt.Fprintf(&b, "|> group(columns: [%s])\n", strings.Join(columns, ", "))
+	b.WriteString("|> sort(columns: [\"_time\"])\n")
+	// Counters are integers; quantile only works on floats
+	b.WriteString("|> toFloat()\n")
+	if weighted {
+		// Means are weighted by the point count of their rollup, as in the
+		// embedded store; rollups written before counts were kept weigh 1
+		b.WriteString("|> pivot(rowKey: [\"_time\"], columnKey: [\"stat\"], valueColumn: \"_value\")\n")
+		b.WriteString("|> filter(fn: (r) => exists r.mean)\n")
+		b.WriteString("|> map(fn: (r) => ({r with _value: r.mean, weight: if exists r.count then r.count else 1.0}))\n")
+		fmt.Fprintf(&b, "|> aggregateWindow(every: %s, fn: weightedMean, createEmpty: false)\n", fluxDuration(q.Window))
+		return fluxWeightedMean + b.String()
+	}
+	if q.Aggregate == RateAggregate {
+		fmt.Fprintf(&b, "|> aggregateWindow(every: %s, fn: last, createEmpty: false)\n", fluxDuration(q.Window))
+		b.WriteString("|> derivative(unit: 1s, nonNegative: true)\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "|> aggregateWindow(every: %s, fn: %s, createEmpty: false)\n", fluxDuration(q.Window), fluxAggregate(q.Aggregate))
+	return b.String()
+}
+
+// fluxWeightedMean defines the aggregateWindow function combining rollup
+// means by their weight column
+const fluxWeightedMean = `weightedMean = (column, tables=<-) => tables
+|> reduce(identity: {total: 0.0, weight: 0.0}, fn: (r, accumulator) => ({total: accumulator.total + r._value * r.weight, weight: accumulator.weight + r.weight}))
+|> map(fn: (r) => ({r with _value: r.total / r.weight}))
+
+`
+
+// fluxRollup builds the Flux script that rolls the raw points of
+// [from, to) up into dest: one series per stat, plus the point count,
+// told apart by a stat tag and stamped with the start of their window
+func fluxRollup(bucket, dest, org string, tier Tier, from, to time.Time) string {
+	var b strings.Builder
+	b.WriteString("import \"experimental\"\n\n")
+	fmt.Fprintf(&b, "data = from(bucket: %s)\n", fluxString(bucket))
+	fmt.Fprintf(&b, "|> range(start: %s, stop: %s)\n", from.UTC().Format(time.RFC3339Nano), to.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "|> filter(fn: (r) => %s)\n", fluxAnyOf("_measurement", MeasurementNames()))
+	b.WriteString("|> toFloat()\n\n")
+
+	b.WriteString("rollup = (stat, fn) => data\n")
+	fmt.Fprintf(&b, "|> aggregateWindow(every: %s, fn: fn, createEmpty: false, timeSrc: \"_start\")\n", fluxDuration(tier.Resolution))
+	b.WriteString("|> set(key: \"stat\", value: stat)\n")
+	b.WriteString("|> experimental.group(columns: [\"stat\"], mode: \"extend\")\n\n")
+
+	rollups := make([]string, len(RollupStats))
+	for i, stat := range RollupStats {
+		rollups[i] = fmt.Sprintf("rollup(stat: %s, fn: %s)", fluxString(stat), fluxAggregate(stat))
+	}
+	// Stored as a float like the other stats, since they share the field
+	rollups = append(rollups, fmt.Sprintf("rollup(stat: %s, fn: (column, tables=<-) => tables |> count(column: column) |> toFloat())", fluxString(rollupCount)))
+	fmt.Fprintf(&b, "union(tables: [\n%s\n])\n", strings.Join(rollups, ",\n"))
+	fmt.Fprintf(&b, "|> to(bucket: %s, org: %s)\n", fluxString(dest), fluxString(org))
+	// Nothing needs to come back
+	b.WriteString("|> filter(fn: (r) => false)\n")
+	return b.String()
+}
+
+// fluxAggregate returns the aggregateWindow function for an aggregate
+func fluxAggregate(aggregate string) string {
+	switch aggregate {
+	case "p95":
+		return "(column, tables=<-) => tables |> quantile(q: 0.95, column: column)"
+	case "p99":
+		return "(column, tables=<-) => tables |> quantile(q: 0.99, column: column)"
+	}
+	return aggregate
+}
+
+// fluxAnyOf matches a column against any of the values
+func fluxAnyOf(column string, values []string) string {
+	conditions := make([]string, len(values))
+	for i, value := range values {
+		conditions[i] = fmt.Sprintf("r[%s] == %s", fluxString(column), fluxString(value))
+	}
+	return strings.Join(conditions, " or ")
+}
+
+// fluxString quotes s as a Flux string literal. Besides quotes and
+// backslashes, "${" must be escaped because Flux interpolates it.
+func fluxString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// fluxDuration formats d as a Flux duration literal
+func fluxDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
+
+// toFloat converts a Flux value to float64; aggregates keep the integer
+// types of fields like totals and counters
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
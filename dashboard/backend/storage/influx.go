@@ -4,18 +4,25 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
 
 type InfluxDB struct {
 	client   influxdb2.Client
-	writeAPI api.WriteAPI
+	writes   *influxWriter
 	queryAPI api.QueryAPI
 	bucket   string
 	org      string
+	tiers    Tiers
+
+	// Rollup buckets known to exist
+	buckets   map[string]bool
+	bucketsMu sync.Mutex
 }
 
 type InfluxConfig struct {
@@ -23,6 +30,18 @@ type InfluxConfig struct {
 	Token  string
 	Org    string
 	Bucket string
+	// Points of all agents are written together once BatchSize are
+	// pending or every FlushInterval
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxPending bounds the points waiting to be written; WriteMetrics
+	// fails with ErrWriteQueueFull beyond it
+	MaxPending int
+	// MaxRetries for a batch that failed with a network error, 429 or 5xx
+	MaxRetries int
+	// Tiers beyond raw are kept in their own bucket, named after Bucket
+	// and the tier, e.g. metrics_5m
+	Tiers Tiers
 }
 
 func NewInfluxDB(config InfluxConfig) *InfluxDB {
@@ -30,33 +49,65 @@ func NewInfluxDB(config InfluxConfig) *InfluxDB {
 	
 	return &InfluxDB{
 		client:   client,
-		writeAPI: client.WriteAPI(config.Org, config.Bucket),
+		writes:   newInfluxWriter(client.WriteAPIBlocking(config.Org, config.Bucket), config),
 		queryAPI: client.QueryAPI(config.Org),
 		bucket:   config.Bucket,
 		org:      config.Org,
+		tiers:    config.Tiers,
+		buckets:  make(map[string]bool),
 	}
 }
 
-// WriteMetrics writes system metrics to InfluxDB
+// WriteMetrics queues system metrics for the next write to InfluxDB
 func (db *InfluxDB) WriteMetrics(agentID string, metrics *SystemMetrics) error {
-	timestamp := time.Now()
+	points := make([]*write.Point, 0, 3+len(metrics.Disks)+len(metrics.Networks))
+
+	// Points carry the time the host measured them
+	timestamp := metrics.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
 
 	hostname := metrics.Hostname
 
 	// CPU metrics
+	cpuTags := map[string]string{
+		"agent_id": agentID,
+		"hostname": hostname,
+	}
+	if metrics.CPUModel != "" {
+		cpuTags["model"] = metrics.CPUModel
+	}
 	cpuPoint := influxdb2.NewPoint(
 		"cpu",
-		map[string]string{
-			"agent_id": agentID,
-			"hostname": hostname,
-		},
+		cpuTags,
 		map[string]interface{}{
 			"usage_percent": metrics.CPUPercent,
 			"core_count":    metrics.CoreCount,
 		},
 		timestamp,
 	)
-	db.writeAPI.WritePoint(cpuPoint)
+	points = append(points, cpuPoint)
+
+	// Host-wide metrics
+	systemFields := map[string]interface{}{
+		"uptime": metrics.Uptime,
+	}
+	if len(metrics.LoadAvg) == 3 {
+		systemFields["load1"] = metrics.LoadAvg[0]
+		systemFields["load5"] = metrics.LoadAvg[1]
+		systemFields["load15"] = metrics.LoadAvg[2]
+	}
+	systemPoint := influxdb2.NewPoint(
+		"system",
+		map[string]string{
+			"agent_id": agentID,
+			"hostname": hostname,
+		},
+		systemFields,
+		timestamp,
+	)
+	points = append(points, systemPoint)
 
 	// Memory metrics
 	memPoint := influxdb2.NewPoint(
@@ -73,7 +124,7 @@ func (db *InfluxDB) WriteMetrics(agentID string, metrics *SystemMetrics) error {
 		},
 		timestamp,
 	)
-	db.writeAPI.WritePoint(memPoint)
+	points = append(points, memPoint)
 
 	// Disk metrics
 	for _, disk := range metrics.Disks {
@@ -84,6 +135,7 @@ func (db *InfluxDB) WriteMetrics(agentID string, metrics *SystemMetrics) error {
 				"hostname":    hostname,
 				"mount_point": disk.MountPoint,
 				"device":      disk.Device,
+				"fs_type":     disk.FSType,
 			},
 			map[string]interface{}{
 				"total":        disk.Total,
@@ -93,7 +145,7 @@ func (db *InfluxDB) WriteMetrics(agentID string, metrics *SystemMetrics) error {
 			},
 			timestamp,
 		)
-		db.writeAPI.WritePoint(diskPoint)
+		points = append(points, diskPoint)
 	}
 
 	// Network metrics
@@ -113,84 +165,171 @@ func (db *InfluxDB) WriteMetrics(agentID string, metrics *SystemMetrics) error {
 			},
 			timestamp,
 		)
-		db.writeAPI.WritePoint(netPoint)
+		points = append(points, netPoint)
 	}
 
-	// Flush writes
-	db.writeAPI.Flush()
-
-	return nil
+	return db.writes.enqueue(agentID, points)
 }
 
-// QueryMetrics retrieves historical metrics
-func (db *InfluxDB) QueryMetrics(agentID string, measurement string, duration time.Duration) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf(`
-		from(bucket: "%s")
-		|> range(start: -%s)
-		|> filter(fn: (r) => r["_measurement"] == "%s")
-		|> filter(fn: (r) => r["agent_id"] == "%s")
-		|> aggregateWindow(every: 30s, fn: mean, createEmpty: false)
-		|> yield(name: "mean")
-	`, db.bucket, duration.String(), measurement, agentID)
-
-	result, err := db.queryAPI.Query(context.Background(), query)
+// QueryMetrics retrieves historical metrics. Every value from the request
+// is validated or quoted as a Flux literal; the parameters API would be
+// cleaner but InfluxDB OSS doesn't support it.
+func (db *InfluxDB) QueryMetrics(q HistoryQuery) (*HistoryResult, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	tier := db.tiers.ForStart(q.Start, time.Now())
+	q.useTier(tier)
+
+	result, err := db.queryAPI.Query(context.Background(), q.fluxQuery(db.tierBucket(tier), tier))
 	if err != nil {
 		return nil, err
 	}
 
-	var records []map[string]interface{}
+	history := &HistoryResult{
+		Measurement: q.Measurement,
+		Start:       q.Start,
+		End:         q.End,
+		Window:      q.Window,
+		Aggregate:   q.Aggregate,
+		Tier:        tier.Name,
+		Series:      make([]HistorySeries, 0),
+	}
+	if len(q.AgentIDs) == 1 {
+		history.AgentID = q.AgentIDs[0]
+	}
+	tags := Measurements[q.Measurement].Tags
 	for result.Next() {
-		record := make(map[string]interface{})
-		record["time"] = result.Record().Time()
-		record["value"] = result.Record().Value()
-		
-		// Add all fields and tags
-		for k, v := range result.Record().Values() {
-			record[k] = v
+		record := result.Record()
+		if result.TableChanged() || len(history.Series) == 0 {
+			agentID, _ := record.ValueByKey("agent_id").(string)
+			series := HistorySeries{AgentID: agentID, Field: record.Field()}
+			for _, tag := range tags {
+				if value, ok := record.ValueByKey(tag).(string); ok && value != "" {
+					if series.Tags == nil {
+						series.Tags = make(map[string]string)
+					}
+					series.Tags[tag] = value
+				}
+			}
+			history.Series = append(history.Series, series)
+		}
+
+		value, ok := toFloat(record.Value())
+		if !ok {
+			continue
 		}
-		
-		records = append(records, record)
+		series := &history.Series[len(history.Series)-1]
+		series.Times = append(series.Times, record.Time().UnixMilli())
+		series.Values = append(series.Values, value)
 	}
 
 	if result.Err() != nil {
 		return nil, result.Err()
 	}
 
-	return records, nil
+	return history, nil
 }
 
-// Close closes the InfluxDB client
-func (db *InfluxDB) Close() {
-	db.client.Close()
-	log.Println("InfluxDB client closed")
+// rollupState marks how far a rollup bucket is filled: one point per
+// rollup, at its end
+const rollupState = "rollup_state"
+
+// Rollup runs the rollup as a Flux query, so the points never leave
+// InfluxDB, then records its end
+func (db *InfluxDB) Rollup(tier Tier, from, to time.Time) error {
+	ctx := context.Background()
+	dest := db.tierBucket(tier)
+	if err := db.ensureBucket(ctx, dest); err != nil {
+		return err
+	}
+
+	result, err := db.queryAPI.Query(ctx, fluxRollup(db.bucket, dest, db.org, tier, from, to))
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+	for result.Next() {
+	}
+	if result.Err() != nil {
+		return result.Err()
+	}
+
+	state := influxdb2.NewPoint(rollupState, nil, map[string]interface{}{"done": true}, to)
+	return db.client.WriteAPIBlocking(db.org, dest).WritePoint(ctx, state)
+}
+
+// RolledUpTo returns the time of the last rollup_state point
+func (db *InfluxDB) RolledUpTo(tier Tier) (time.Time, error) {
+	ctx := context.Background()
+	dest := db.tierBucket(tier)
+	if err := db.ensureBucket(ctx, dest); err != nil {
+		return time.Time{}, err
+	}
+
+	query := fmt.Sprintf("from(bucket: %s)\n|> range(start: 1970-01-01T00:00:00Z)\n|> filter(fn: (r) => r[\"_measurement\"] == %s)\n|> last()\n",
+		fluxString(dest), fluxString(rollupState))
+	result, err := db.queryAPI.Query(ctx, query)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer result.Close()
+
+	var to time.Time
+	for result.Next() {
+		if t := result.Record().Time(); t.After(to) {
+			to = t
+		}
+	}
+	return to, result.Err()
+}
+
+// Prune deletes every point of tier older than before
+func (db *InfluxDB) Prune(tier Tier, before time.Time) error {
+	return db.client.DeleteAPI().DeleteWithName(context.Background(), db.org, db.tierBucket(tier), time.Unix(0, 0), before, "")
+}
+
+// tierBucket names the bucket holding a tier
+func (db *InfluxDB) tierBucket(tier Tier) string {
+	if !tier.Rollup() {
+		return db.bucket
+	}
+	return db.bucket + "_" + tier.Name
 }
 
-// SystemMetrics represents the metrics structure from agents
-type SystemMetrics struct {
-	Hostname     string
-	CPUPercent   float64
-	CoreCount    int
-	MemTotal     uint64
-	MemUsed      uint64
-	MemAvailable uint64
-	MemPercent   float64
-	Disks        []DiskMetric
-	Networks     []NetworkMetric
+// ensureBucket creates a rollup bucket the first time it is needed. It
+// gets no retention rule of its own: Retention prunes it.
+func (db *InfluxDB) ensureBucket(ctx context.Context, name string) error {
+	db.bucketsMu.Lock()
+	defer db.bucketsMu.Unlock()
+	if db.buckets[name] {
+		return nil
+	}
+
+	buckets := db.client.BucketsAPI()
+	if _, err := buckets.FindBucketByName(ctx, name); err != nil {
+		org, err := db.client.OrganizationsAPI().FindOrganizationByName(ctx, db.org)
+		if err != nil {
+			return fmt.Errorf("failed to find organization %s: %w", db.org, err)
+		}
+		if _, err := buckets.CreateBucketWithName(ctx, org, name); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", name, err)
+		}
+		log.Printf("Created InfluxDB bucket %s", name)
+	}
+	db.buckets[name] = true
+	return nil
 }
 
-type DiskMetric struct {
-	Device      string
-	MountPoint  string
-	Total       uint64
-	Used        uint64
-	Free        uint64
-	UsedPercent float64
+// WriteStats reports the health of the write queue
+func (db *InfluxDB) WriteStats() WriteStats {
+	return db.writes.snapshot()
 }
 
-type NetworkMetric struct {
-	Interface   string
-	BytesSent   uint64
-	BytesRecv   uint64
-	PacketsSent uint64
-	PacketsRecv uint64
-}
\ No newline at end of file
+// Close writes the queued points and closes the InfluxDB client
+func (db *InfluxDB) Close() error {
+	db.writes.stop()
+	db.client.Close()
+	log.Println("InfluxDB client closed")
+	return nil
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	influxhttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"github.com/AzertoxHDW/sentinel/dashboard/backend/batch"
+)
+
+// ErrWriteQueueFull is returned by WriteMetrics while the write queue is
+// full, e.g. because InfluxDB is down
+var ErrWriteQueueFull = errors.New("metrics write queue full")
+
+// WriteStats describes a buffered metrics write pipeline
+type WriteStats struct {
+	WrittenPoints  uint64     `json:"written_points"`
+	WrittenBatches uint64     `json:"written_batches"`
+	FailedBatches  uint64     `json:"failed_batches"` // Given up after retries or rejected
+	Retries        uint64     `json:"retries"`
+	DroppedPoints  uint64     `json:"dropped_points"`  // Lost with failed batches
+	RejectedPoints uint64     `json:"rejected_points"` // Refused because the queue was full
+	Pending        int        `json:"pending_points"`
+	MaxPending     int        `json:"max_pending_points"`
+	Retrying       bool       `json:"retrying"` // A failed batch is waiting for its next attempt
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time `json:"last_error_at,omitempty"`
+	LastWriteAt    *time.Time `json:"last_write_at,omitempty"`
+	// Agents whose points failed to be written, by agent ID
+	Agents map[string]*AgentWriteErrors `json:"agents,omitempty"`
+}
+
+// AgentWriteErrors counts the write failures of one agent
+type AgentWriteErrors struct {
+	FailedWrites   uint64    `json:"failed_writes"` // Attempts that included its points
+	DroppedPoints  uint64    `json:"dropped_points"`
+	RejectedPoints uint64    `json:"rejected_points"`
+	LastError      string    `json:"last_error"`
+	LastErrorAt    time.Time `json:"last_error_at"`
+}
+
+// WriteReporter is implemented by metrics stores that buffer writes
+type WriteReporter interface {
+	WriteStats() WriteStats
+}
+
+type queuedPoint struct {
+	agentID string
+	point   *write.Point
+}
+
+// influxWriter batches the points of all agents and writes them from a
+// background goroutine. A failed batch stays at the head of the queue and
+// is retried with backoff while new points wait behind it; once the queue
+// is full WriteMetrics fails instead of growing it further.
+type influxWriter struct {
+	writeAPI api.WriteAPIBlocking
+	timeout  time.Duration
+	queue    *batch.Queue[queuedPoint]
+
+	// Failures by agent ID
+	agents map[string]*AgentWriteErrors
+	mu     sync.Mutex
+}
+
+func newInfluxWriter(writeAPI api.WriteAPIBlocking, config InfluxConfig) *influxWriter {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 5000
+	}
+
+	w := &influxWriter{
+		writeAPI: writeAPI,
+		timeout:  10 * time.Second,
+	}
+	w.queue = batch.New(batch.Config{
+		BatchSize:     config.BatchSize,
+		FlushInterval: config.FlushInterval,
+		MaxPending:    config.MaxPending,
+		MaxRetries:    config.MaxRetries,
+		MinBackoff:    time.Second,
+		MaxBackoff:    30 * time.Second,
+	}, w.writeBatch, w.failed)
+	w.queue.Start()
+	return w
+}
+
+// enqueue queues the points of one sample, all or none
+func (w *influxWriter) enqueue(agentID string, points []*write.Point) error {
+	queued := make([]queuedPoint, len(points))
+	for i, point := range points {
+		queued[i] = queuedPoint{agentID: agentID, point: point}
+	}
+
+	if err := w.queue.Add(queued...); err != nil {
+		w.mu.Lock()
+		w.agentError(agentID, ErrWriteQueueFull).RejectedPoints += uint64(len(points))
+		w.mu.Unlock()
+		return fmt.Errorf("%w (%d points pending)", ErrWriteQueueFull, w.queue.Config().MaxPending)
+	}
+	return nil
+}
+
+// agentError records err against an agent; the caller holds mu
+func (w *influxWriter) agentError(agentID string, err error) *AgentWriteErrors {
+	if w.agents == nil {
+		w.agents = make(map[string]*AgentWriteErrors)
+	}
+	agent, ok := w.agents[agentID]
+	if !ok {
+		agent = &AgentWriteErrors{}
+		w.agents[agentID] = agent
+	}
+	agent.LastError = err.Error()
+	agent.LastErrorAt = time.Now()
+	return agent
+}
+
+func (w *influxWriter) snapshot() WriteStats {
+	queued := w.queue.Stats()
+	stats := WriteStats{
+		WrittenPoints:  queued.Sent,
+		WrittenBatches: queued.SentBatches,
+		FailedBatches:  queued.FailedBatches,
+		Retries:        queued.Retries,
+		DroppedPoints:  queued.Failed,
+		RejectedPoints: queued.Overflowed,
+		Pending:        queued.Pending,
+		MaxPending:     w.queue.Config().MaxPending,
+		Retrying:       queued.Retrying,
+		LastError:      queued.LastError,
+		LastErrorAt:    queued.LastErrorAt,
+		LastWriteAt:    queued.LastSentAt,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.agents) > 0 {
+		stats.Agents = make(map[string]*AgentWriteErrors, len(w.agents))
+		for id, agent := range w.agents {
+			copied := *agent
+			stats.Agents[id] = &copied
+		}
+	}
+	return stats
+}
+
+// stop writes what is still pending and waits for it
+func (w *influxWriter) stop() {
+	w.queue.Stop()
+}
+
+// writeBatch makes one attempt at writing a batch
+func (w *influxWriter) writeBatch(queued []queuedPoint) (bool, time.Duration, error) {
+	points := make([]*write.Point, len(queued))
+	for i, q := range queued {
+		points[i] = q.point
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	if err := w.writeAPI.WritePoint(ctx, points...); err != nil {
+		retry, wait := retryable(err)
+		return retry, wait, err
+	}
+	return false, 0, nil
+}
+
+// failed records a failed write against the agents in the batch
+func (w *influxWriter) failed(queued []queuedPoint, err error, giveUp bool, wait time.Duration) {
+	counts := countByAgent(queued)
+
+	w.mu.Lock()
+	for agentID, n := range counts {
+		agent := w.agentError(agentID, err)
+		agent.FailedWrites++
+		if giveUp {
+			agent.DroppedPoints += uint64(n)
+		}
+	}
+	w.mu.Unlock()
+
+	if giveUp {
+		for agentID, n := range counts {
+			log.Printf("InfluxDB write failed, dropping %d points of %s: %v", n, agentID, err)
+		}
+		return
+	}
+	log.Printf("InfluxDB write of %d points from %d agents failed, retrying in %v: %v", len(queued), len(counts), wait, err)
+}
+
+// retryable reports whether a write error is worth retrying and how long
+// the server asked to wait: network errors, 429 and 5xx are; other
+// statuses mean InfluxDB rejected the points
+func retryable(err error) (bool, time.Duration) {
+	var httpErr *influxhttp.Error
+	if !errors.As(err, &httpErr) || httpErr.StatusCode == 0 {
+		return true, 0
+	}
+	wait := time.Duration(httpErr.RetryAfter) * time.Second
+	return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode/100 == 5, wait
+}
+
+func countByAgent(queued []queuedPoint) map[string]int {
+	counts := make(map[string]int)
+	for _, q := range queued {
+		counts[q.agentID]++
+	}
+	return counts
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// MetricsWriter receives every sample collected from agents
+type MetricsWriter interface {
+	// WriteMetrics stores one sample of an agent
+	WriteMetrics(agentID string, metrics *SystemMetrics) error
+}
+
+// MetricsStore keeps the time series collected from agents. InfluxDB and
+// the embedded BoltMetrics implement it.
+type MetricsStore interface {
+	MetricsWriter
+	// QueryMetrics validates and runs a history query on the tier that
+	// covers its range
+	QueryMetrics(q HistoryQuery) (*HistoryResult, error)
+	// Rollup summarizes the raw points of [from, to) into a rollup tier;
+	// from and to are aligned to the tier's resolution. Rolling up the same
+	// range again overwrites the earlier rollups.
+	Rollup(tier Tier, from, to time.Time) error
+	// RolledUpTo returns the end of the last rollup into tier, zero if none
+	RolledUpTo(tier Tier) (time.Time, error)
+	// Prune deletes every point of tier older than before
+	Prune(tier Tier, before time.Time) error
+	Close() error
+}
+
+// Rollup scheduling
+const (
+	// rollupDelay leaves late samples time to arrive before their window
+	// is rolled up
+	rollupDelay = time.Minute
+	// rollupChunk bounds the range rolled up at once when catching up, so
+	// each write of rollups stays short
+	rollupChunk = time.Hour
+	// rollupLookback is how far back the first rollup reaches when raw
+	// points are kept forever
+	rollupLookback = 30 * 24 * time.Hour
+)
+
+// Retention manages the tiers of a MetricsStore: it rolls raw points up
+// into each rollup tier as their windows close and prunes every tier to
+// its Keep
+type Retention struct {
+	metrics       MetricsStore
+	tiers         Tiers
+	interval      time.Duration
+	pruneInterval time.Duration
+	stopChan      chan struct{}
+}
+
+func NewRetention(metrics MetricsStore, tiers Tiers) *Retention {
+	return &Retention{
+		metrics:       metrics,
+		tiers:         tiers,
+		interval:      time.Minute,
+		pruneInterval: time.Hour,
+		stopChan:      make(chan struct{}),
+	}
+}
+
+func (r *Retention) Start() {
+	ticker := time.NewTicker(r.interval)
+	go func() {
+		r.rollup()
+		r.prune()
+		lastPrune := time.Now()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.rollup()
+				if time.Since(lastPrune) >= r.pruneInterval {
+					r.prune()
+					lastPrune = time.Now()
+				}
+			case <-r.stopChan:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	desc := make([]string, len(r.tiers))
+	for i, tier := range r.tiers {
+		keep := "forever"
+		if tier.Keep > 0 {
+			keep = tier.Keep.String()
+		}
+		desc[i] = tier.Name + ": " + keep
+	}
+	log.Printf("Metrics retention started (%s)", strings.Join(desc, ", "))
+}
+
+func (r *Retention) Stop() {
+	close(r.stopChan)
+}
+
+// rollup brings every rollup tier up to the last closed window
+func (r *Retention) rollup() {
+	now := time.Now()
+	for _, tier := range r.tiers {
+		if !tier.Rollup() {
+			continue
+		}
+
+		from, err := r.metrics.RolledUpTo(tier)
+		if err != nil {
+			log.Printf("Failed to read %s rollup progress: %v", tier.Name, err)
+			continue
+		}
+		if from.IsZero() {
+			lookback := r.tiers[0].Keep
+			if lookback == 0 {
+				lookback = rollupLookback
+			}
+			from = now.Add(-lookback)
+		}
+		from = from.Truncate(tier.Resolution)
+		to := now.Add(-rollupDelay).Truncate(tier.Resolution)
+		if !from.Before(to) {
+			continue
+		}
+
+		// Chunks hold whole windows
+		chunk := max(rollupChunk.Truncate(tier.Resolution), tier.Resolution)
+
+		start := time.Now()
+		catchUp := to.Sub(from) > tier.Resolution
+		for from.Before(to) {
+			end := to
+			if end.Sub(from) > chunk {
+				end = from.Add(chunk)
+			}
+			if err := r.metrics.Rollup(tier, from, end); err != nil {
+				log.Printf("Failed to roll up metrics from %v into the %s tier: %v", from, tier.Name, err)
+				break
+			}
+			from = end
+		}
+		if catchUp {
+			log.Printf("Rolled up metrics into the %s tier up to %v in %v", tier.Name, from, time.Since(start).Round(time.Millisecond))
+		}
+	}
+}
+
+func (r *Retention) prune() {
+	for _, tier := range r.tiers {
+		if tier.Keep <= 0 {
+			continue
+		}
+		start := time.Now()
+		if err := r.metrics.Prune(tier, start.Add(-tier.Keep)); err != nil {
+			log.Printf("Failed to prune %s metrics: %v", tier.Name, err)
+			continue
+		}
+		log.Printf("Pruned %s metrics older than %v in %v", tier.Name, tier.Keep, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// SystemMetrics represents the metrics structure from agents
+type SystemMetrics struct {
+	Timestamp    time.Time // When the agent took the sample
+	Hostname     string
+	Uptime       uint64 // Seconds
+	CPUPercent   float64
+	CoreCount    int
+	CPUModel     string
+	LoadAvg      []float64 // 1, 5 and 15 minute averages; empty on Windows
+	MemTotal     uint64
+	MemUsed      uint64
+	MemAvailable uint64
+	MemPercent   float64
+	Disks        []DiskMetric
+	Networks     []NetworkMetric
+}
+
+type DiskMetric struct {
+	Device      string
+	MountPoint  string
+	FSType      string
+	Total       uint64
+	Used        uint64
+	Free        uint64
+	UsedPercent float64
+}
+
+type NetworkMetric struct {
+	Interface   string
+	BytesSent   uint64
+	BytesRecv   uint64
+	PacketsSent uint64
+	PacketsRecv uint64
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	keySchemaVersion = []byte("schema_version")
+	keyLegacyImport  = []byte("legacy_import")
+)
+
+// migrations upgrade the database schema. Entry i moves the schema from
+// version i to i+1; append new steps, never edit released ones.
+var migrations = []func(tx *bolt.Tx) error{
+	// 1: agents and their status history
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketAgents, bucketEvents} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: group settings
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketGroups)
+		return err
+	},
+}
+
+// migrate applies pending migrations, each in its own transaction
+func (s *BoltStore) migrate() error {
+	for {
+		var version uint64
+		applied := false
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+			if err != nil {
+				return err
+			}
+			if v := meta.Get(keySchemaVersion); v != nil {
+				version = binary.BigEndian.Uint64(v)
+			}
+			if version > uint64(len(migrations)) {
+				return fmt.Errorf("database schema version %d is newer than this dashboard supports (%d)", version, len(migrations))
+			}
+			if version == uint64(len(migrations)) {
+				return nil
+			}
+
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migration to schema version %d failed: %w", version+1, err)
+			}
+			version++
+			applied = true
+
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, version)
+			return meta.Put(keySchemaVersion, v)
+		})
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return nil
+		}
+		log.Printf("Database migrated to schema version %d", version)
+	}
+}
+
+// ImportJSON copies agents from a legacy agents.json file into the
+// database. It runs once: later calls, or a missing file, do nothing. The
+// file is renamed with an .imported suffix afterwards.
+func (s *BoltStore) ImportJSON(file string) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var agents []*Agent
+	if err := json.Unmarshal(data, &agents); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	imported := 0
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(keyLegacyImport) != nil {
+			return nil
+		}
+
+		for _, agent := range agents {
+			// Agents added since (e.g. by discovery) win over stale copies
+			if existing, err := getAgent(tx, agent.ID); err != nil || existing != nil {
+				continue
+			}
+			if err := putAgent(tx, agent); err != nil {
+				return err
+			}
+			imported++
+		}
+		return meta.Put(keyLegacyImport, []byte(file))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %w", file, err)
+	}
+
+	log.Printf("Imported %d agents from %s", imported, file)
+	if err := os.Rename(file, file+".imported"); err != nil {
+		log.Printf("Failed to rename %s after import: %v", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoMetricsStore is returned by history queries when the dashboard
+// doesn't store metrics itself
+var ErrNoMetricsStore = errors.New("metrics are not stored by the dashboard")
+
+// NoMetrics is the MetricsStore of -metrics-backend=none: samples are only
+// forwarded, e.g. by remote write, and history queries fail with
+// ErrNoMetricsStore
+type NoMetrics struct{}
+
+func (NoMetrics) WriteMetrics(agentID string, metrics *SystemMetrics) error {
+	return nil
+}
+
+func (NoMetrics) QueryMetrics(q HistoryQuery) (*HistoryResult, error) {
+	return nil, ErrNoMetricsStore
+}
+
+func (NoMetrics) Rollup(tier Tier, from, to time.Time) error {
+	return nil
+}
+
+func (NoMetrics) RolledUpTo(tier Tier) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (NoMetrics) Prune(tier Tier, before time.Time) error {
+	return nil
+}
+
+func (NoMetrics) Close() error {
+	return nil
+}
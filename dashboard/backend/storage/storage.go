@@ -1,125 +1,222 @@
 package storage
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Agent struct {
-	ID          string    `json:"id"`
-	Hostname    string    `json:"hostname"`
-	IPAddress   string    `json:"ip_address"`
-	Port        int       `json:"port"`
-	AddedAt     time.Time `json:"added_at"`
-	LastSeen    time.Time `json:"last_seen"`
-	Status      string    `json:"status"` // online, offline, unknown
+	ID        string     `json:"id"`
+	Hostname  string     `json:"hostname"`
+	IPAddress string     `json:"ip_address"` // Active address used for collection
+	Addresses []string   `json:"addresses,omitempty"`
+	Family    string     `json:"address_family,omitempty"` // auto, ipv4, ipv6
+	Port      int        `json:"port"`
+	AddedAt   time.Time  `json:"added_at"`
+	LastSeen  time.Time  `json:"last_seen,omitzero"` // Last successful poll
+	Status    string     `json:"status"`             // See Status* constants
+	Failures  int        `json:"failures"`           // Consecutive failed polls
+	NextPoll  *time.Time `json:"next_poll,omitempty"`
+	// How far the agent's clock is off, from its last sample
+	ClockSkewMS int64 `json:"clock_skew_ms,omitempty"`
+	// Payload schema of the last answer and why it may not be fully read
+	SchemaVersion int          `json:"schema_version,omitempty"`
+	SchemaWarning string       `json:"schema_warning,omitempty"`
+	AgentHealth   *AgentHealth `json:"agent_health,omitempty"`
+	Connection
+	AgentMetadata
 }
 
-type Store struct {
-	agents map[string]*Agent
-	mu     sync.RWMutex
-	file   string
+// Connection holds how the dashboard talks to an agent
+type Connection struct {
+	// Scheme is https for agents serving TLS; empty means http
+	Scheme string `json:"scheme,omitempty"`
+	// AuthToken is sent as a bearer token to agents that require one. It is
+	// stored but never returned by the API; AuthTokenSet reports it.
+	AuthToken    string `json:"-"`
+	AuthTokenSet bool   `json:"auth_token_set,omitempty"`
 }
 
-func NewStore(file string) (*Store, error) {
-	s := &Store{
-		agents: make(map[string]*Agent),
-		file:   file,
-	}
+// LegacyID is the hostname:port ID of agents that don't report a UUID
+func LegacyID(hostname string, port int) string {
+	return fmt.Sprintf("%s:%d", hostname, port)
+}
 
-	// Load existing agents from file
-	if err := s.load(); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to load agents: %w", err)
+// HasLegacyID reports whether the agent is registered under a LegacyID
+// rather than the UUID it reports
+func (a *Agent) HasLegacyID() bool {
+	i := strings.LastIndexByte(a.ID, ':')
+	if i < 0 {
+		return false
 	}
-
-	return s, nil
+	_, err := strconv.Atoi(a.ID[i+1:])
+	return err == nil
 }
 
-func (s *Store) AddAgent(agent *Agent) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	agent.AddedAt = time.Now()
-	agent.LastSeen = time.Now()
-	agent.Status = "online"
-	
-	s.agents[agent.ID] = agent
+// Agent statuses
+const (
+	StatusUnknown  = "unknown"  // Never polled
+	StatusOnline   = "online"   // Last poll succeeded
+	StatusDegraded = "degraded" // Answering, but slowly or with failing collectors
+	StatusStale    = "stale"    // Missed StaleAfter polls in a row
+	StatusOffline  = "offline"  // Missed OfflineAfter polls in a row
+	StatusPaused   = "paused"   // Polling suspended by the user
+)
 
-	return s.save()
+// AgentMetadata holds the user-editable details and settings of an agent
+type AgentMetadata struct {
+	DisplayName string   `json:"display_name,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Location    string   `json:"location,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	// Thresholds and Polling override the group and global settings
+	Thresholds *Thresholds `json:"thresholds,omitempty"`
+	Polling    *Polling    `json:"polling,omitempty"`
 }
 
-func (s *Store) GetAgent(id string) (*Agent, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	agent, exists := s.agents[id]
-	return agent, exists
+// Thresholds drive the status state machine. Zero fields inherit from the
+// next level: agent, then its groups in order, then the global defaults.
+type Thresholds struct {
+	SlowResponseMS int `json:"slow_response_ms,omitempty"` // Slower polls mark the agent degraded
+	StaleAfter     int `json:"stale_after,omitempty"`      // Missed polls before stale
+	OfflineAfter   int `json:"offline_after,omitempty"`    // Missed polls before offline
 }
 
-func (s *Store) GetAllAgents() []*Agent {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	agents := make([]*Agent, 0, len(s.agents))
-	for _, agent := range s.agents {
-		agents = append(agents, agent)
-	}
+// Polling controls how often an agent is scraped. Zero fields inherit like
+// Thresholds.
+type Polling struct {
+	IntervalMS   int `json:"interval_ms,omitempty"`
+	TimeoutMS    int `json:"timeout_ms,omitempty"`
+	MaxBackoffMS int `json:"max_backoff_ms,omitempty"` // Cap on the delay between polls of an offline agent
+}
 
-	return agents
+// Group holds settings shared by every agent in the group
+type Group struct {
+	Name       string      `json:"name"`
+	Thresholds *Thresholds `json:"thresholds,omitempty"`
+	Polling    *Polling    `json:"polling,omitempty"`
 }
 
-func (s *Store) RemoveAgent(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// StatusUpdate is the outcome of one poll
+type StatusUpdate struct {
+	Status   string
+	Reason   string
+	Failures int
+	// Seen is true when the agent answered, updating LastSeen
+	Seen     bool
+	NextPoll time.Time
+	// ClockSkew is the agent's clock offset measured by a successful poll
+	ClockSkew time.Duration
+	// SchemaVersion of the decoded payload, 0 when the poll got none
+	SchemaVersion int
+	SchemaWarning string
+}
 
-	delete(s.agents, id)
-	return s.save()
+// AgentHealth summarizes the agent process itself, as opposed to the host it monitors
+type AgentHealth struct {
+	Status            string    `json:"status"` // ok, degraded
+	Version           string    `json:"version"`
+	UptimeSeconds     float64   `json:"uptime_seconds"`
+	Goroutines        int       `json:"goroutines"`
+	RSSBytes          uint64    `json:"rss_bytes"`
+	CollectorErrors   uint64    `json:"collector_errors"`
+	FailingCollectors []string  `json:"failing_collectors,omitempty"`
+	CheckedAt         time.Time `json:"checked_at"`
 }
 
-func (s *Store) UpdateAgentStatus(id string, status string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// ErrAgentNotFound is returned when updating or removing an agent that
+// doesn't exist
+var ErrAgentNotFound = errors.New("agent not found")
 
-	if agent, exists := s.agents[id]; exists {
-		agent.Status = status
-		agent.LastSeen = time.Now()
-		return s.save()
-	}
+// ErrAgentExists is returned when adding an agent that is already registered
+var ErrAgentExists = errors.New("agent already registered")
 
-	return fmt.Errorf("agent not found: %s", id)
-}
+// ErrInvalidCursor is returned for a page cursor not handed out by the store
+var ErrInvalidCursor = errors.New("invalid cursor")
 
-func (s *Store) load() error {
-	data, err := os.ReadFile(s.file)
-	if err != nil {
-		return err
-	}
+// StatusEvent records an agent changing status
+type StatusEvent struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
 
-	var agents []*Agent
-	if err := json.Unmarshal(data, &agents); err != nil {
-		return err
-	}
+// Reasons recorded with status events
+const (
+	ReasonRegistered        = "registered"         // Agent was added
+	ReasonResponded         = "responded"          // Agent answered a poll
+	ReasonTimeout           = "timeout"            // No answer in time
+	ReasonConnectionRefused = "connection_refused" // Nothing listening on the port
+	ReasonUnreachable       = "unreachable"        // Other network errors (no route, DNS, reset)
+	ReasonHTTPError         = "http_error"         // Agent answered with a non-200 status
+	ReasonBadPayload        = "bad_payload"        // Response could not be decoded
+	ReasonSlow              = "slow"               // Answered slower than the threshold
+	ReasonCollectorErrors   = "collector_errors"   // Some agent collectors are failing
+	ReasonPaused            = "paused"             // Polling paused by the user
+	ReasonResumed           = "resumed"            // Polling resumed by the user
+)
 
-	for _, agent := range agents {
-		s.agents[agent.ID] = agent
-	}
+// Store persists registered agents and their status history
+type Store interface {
+	// AddAgent registers a new agent; ErrAgentExists leaves the stored one
+	// untouched
+	AddAgent(agent *Agent) error
+	GetAgent(id string) (*Agent, bool)
+	GetAllAgents() []*Agent
+	// RemoveAgent deletes an agent and its events, or returns
+	// ErrAgentNotFound
+	RemoveAgent(id string) error
+	// UpdateAgentStatus stores the outcome of a poll and records an event
+	// with the reason when the status changes. It is ignored while the
+	// agent is paused.
+	UpdateAgentStatus(id string, update StatusUpdate) error
+	// SetPaused pauses or resumes polling of the agent
+	SetPaused(id string, paused bool) (*Agent, error)
+	// UpdateAgentAddress switches the active address, e.g. after a DHCP change
+	UpdateAgentAddress(id string, ipAddress string, addresses []string) error
+	UpdateAgentHealth(id string, health *AgentHealth) error
+	// UpdateAgentMetadata applies update to the agent's metadata and returns
+	// the updated agent
+	UpdateAgentMetadata(id string, update func(meta *AgentMetadata)) (*Agent, error)
+	// UpdateAgentSettings changes how the agent is reached and its metadata
+	// in one transaction, and returns the updated agent
+	UpdateAgentSettings(id string, update func(conn *Connection, meta *AgentMetadata)) (*Agent, error)
+	// StatusEvents returns up to limit status changes between from and to,
+	// oldest first, starting after cursor if set. When there are more, it
+	// also returns the opaque cursor of the next page.
+	StatusEvents(id string, from, to time.Time, cursor string, limit int) ([]StatusEvent, string, error)
+	// StatusAt returns the status in effect at t according to the history,
+	// or "" when nothing was recorded before t
+	StatusAt(id string, t time.Time) (string, error)
+
+	GetGroup(name string) (*Group, bool)
+	GetAllGroups() []*Group
+	PutGroup(group *Group) error
+	RemoveGroup(name string) error
+
+	Close() error
+}
 
-	return nil
+// HasTag reports whether the agent carries tag (case-insensitive)
+func (a *Agent) HasTag(tag string) bool {
+	return containsFold(a.Tags, tag)
 }
 
-func (s *Store) save() error {
-	agents := make([]*Agent, 0, len(s.agents))
-	for _, agent := range s.agents {
-		agents = append(agents, agent)
-	}
+// InGroup reports whether the agent belongs to group (case-insensitive)
+func (a *Agent) InGroup(group string) bool {
+	return containsFold(a.Groups, group)
+}
 
-	data, err := json.MarshalIndent(agents, "", "  ")
-	if err != nil {
-		return err
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
 	}
-
-	return os.WriteFile(s.file, data, 0644)
-}
\ No newline at end of file
+	return false
+}
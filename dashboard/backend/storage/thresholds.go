@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultThresholds are used when neither the agent nor its groups set one
+var DefaultThresholds = Thresholds{
+	SlowResponseMS: 2000,
+	StaleAfter:     2,
+	OfflineAfter:   5,
+}
+
+// Validate checks thresholds set by the user; zero fields are allowed
+func (t *Thresholds) Validate() error {
+	if t.SlowResponseMS < 0 || t.StaleAfter < 0 || t.OfflineAfter < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+	if t.StaleAfter > 0 && t.OfflineAfter > 0 && t.OfflineAfter < t.StaleAfter {
+		return fmt.Errorf("offline_after must not be lower than stale_after")
+	}
+	return nil
+}
+
+// GroupIndex maps groups by case-folded name, as agents refer to them
+func GroupIndex(groups []*Group) map[string]*Group {
+	index := make(map[string]*Group, len(groups))
+	for _, group := range groups {
+		index[strings.ToLower(group.Name)] = group
+	}
+	return index
+}
+
+// ResolveThresholds fills every threshold from the agent, then its groups in
+// order, then defaults. groups comes from GroupIndex.
+func ResolveThresholds(agent *Agent, groups map[string]*Group, defaults Thresholds) Thresholds {
+	levels := []*Thresholds{agent.Thresholds}
+	for _, name := range agent.Groups {
+		if group, ok := groups[strings.ToLower(name)]; ok {
+			levels = append(levels, group.Thresholds)
+		}
+	}
+	levels = append(levels, &defaults)
+
+	var resolved Thresholds
+	for _, level := range levels {
+		if level == nil {
+			continue
+		}
+		if resolved.SlowResponseMS == 0 {
+			resolved.SlowResponseMS = level.SlowResponseMS
+		}
+		if resolved.StaleAfter == 0 {
+			resolved.StaleAfter = level.StaleAfter
+		}
+		if resolved.OfflineAfter == 0 {
+			resolved.OfflineAfter = level.OfflineAfter
+		}
+	}
+
+	// Overrides at different levels may cross
+	if resolved.OfflineAfter < resolved.StaleAfter {
+		resolved.OfflineAfter = resolved.StaleAfter
+	}
+	return resolved
+}
+
+// Validate checks polling settings set by the user; zero fields are allowed
+func (p *Polling) Validate() error {
+	if p.IntervalMS < 0 || p.TimeoutMS < 0 || p.MaxBackoffMS < 0 {
+		return fmt.Errorf("polling settings must not be negative")
+	}
+	if p.IntervalMS > 0 && p.IntervalMS < 1000 {
+		return fmt.Errorf("interval_ms must be at least 1000")
+	}
+	if p.IntervalMS > 0 && p.TimeoutMS > p.IntervalMS {
+		return fmt.Errorf("timeout_ms must not exceed interval_ms")
+	}
+	if p.IntervalMS > 0 && p.MaxBackoffMS > 0 && p.MaxBackoffMS < p.IntervalMS {
+		return fmt.Errorf("max_backoff_ms must not be lower than interval_ms")
+	}
+	return nil
+}
+
+// Interval, Timeout and MaxBackoff return the settings as durations
+func (p Polling) Interval() time.Duration   { return time.Duration(p.IntervalMS) * time.Millisecond }
+func (p Polling) Timeout() time.Duration    { return time.Duration(p.TimeoutMS) * time.Millisecond }
+func (p Polling) MaxBackoff() time.Duration { return time.Duration(p.MaxBackoffMS) * time.Millisecond }
+
+// ResolvePolling fills every polling setting like ResolveThresholds
+func ResolvePolling(agent *Agent, groups map[string]*Group, defaults Polling) Polling {
+	levels := []*Polling{agent.Polling}
+	for _, name := range agent.Groups {
+		if group, ok := groups[strings.ToLower(name)]; ok {
+			levels = append(levels, group.Polling)
+		}
+	}
+	levels = append(levels, &defaults)
+
+	var resolved Polling
+	for _, level := range levels {
+		if level == nil {
+			continue
+		}
+		if resolved.IntervalMS == 0 {
+			resolved.IntervalMS = level.IntervalMS
+		}
+		if resolved.TimeoutMS == 0 {
+			resolved.TimeoutMS = level.TimeoutMS
+		}
+		if resolved.MaxBackoffMS == 0 {
+			resolved.MaxBackoffMS = level.MaxBackoffMS
+		}
+	}
+
+	// Overrides at different levels may cross
+	if resolved.TimeoutMS > resolved.IntervalMS {
+		resolved.TimeoutMS = resolved.IntervalMS
+	}
+	if resolved.MaxBackoffMS < resolved.IntervalMS {
+		resolved.MaxBackoffMS = resolved.IntervalMS
+	}
+	return resolved
+}
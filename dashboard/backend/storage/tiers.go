@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tier is one resolution metrics are kept at. The raw tier holds samples
+// as collected; rollup tiers summarize the raw points of each Resolution
+// window (point count, mean, min, max, last, p95 and p99), so long ranges
+// stay cheap to keep and to query.
+type Tier struct {
+	Name       string
+	Resolution time.Duration // Zero for raw samples
+	Keep       time.Duration // Zero keeps everything
+}
+
+// RawTier holds samples as collected
+var RawTier = Tier{Name: "raw"}
+
+// Rollup reports whether the tier holds rollups rather than samples
+func (t Tier) Rollup() bool {
+	return t.Resolution > 0
+}
+
+// Tiers lists the tiers a metrics store keeps: raw first, then rollups
+// from finest to coarsest
+type Tiers []Tier
+
+// Validate checks that resolutions grow from tier to tier, that no tier is
+// dropped before a finer one and that raw points live long enough to be
+// rolled up
+func (t Tiers) Validate() error {
+	if len(t) == 0 || t[0].Rollup() {
+		return fmt.Errorf("the first tier must hold raw points")
+	}
+	for i, tier := range t {
+		if tier.Keep < 0 {
+			return fmt.Errorf("%s tier: retention can't be negative", tier.Name)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := t[i-1]
+		if tier.Resolution <= prev.Resolution {
+			return fmt.Errorf("%s tier: resolution must be coarser than the %s tier", tier.Name, prev.Name)
+		}
+		if tier.Keep != 0 && (prev.Keep == 0 || tier.Keep < prev.Keep) {
+			return fmt.Errorf("%s tier: must be kept at least as long as the %s tier", tier.Name, prev.Name)
+		}
+		// Rollups are computed from raw points once their window is over
+		if raw := t[0].Keep; raw != 0 && raw < 2*tier.Resolution {
+			return fmt.Errorf("raw points must be kept at least %v to be rolled up into the %s tier", 2*tier.Resolution, tier.Name)
+		}
+	}
+	return nil
+}
+
+// ForStart picks the tier a query beginning at start reads: the finest one
+// that still holds points that old, or else the one reaching back furthest
+func (t Tiers) ForStart(start, now time.Time) Tier {
+	if len(t) == 0 {
+		return RawTier
+	}
+	for _, tier := range t {
+		if tier.Keep == 0 || !start.Before(now.Add(-tier.Keep)) {
+			return tier
+		}
+	}
+	return t[len(t)-1]
+}
+
+// useTier adapts a validated query to the tier it reads: a rollup tier
+// can't be split finer than its resolution, so the window is rounded up
+// to a multiple of it
+func (q *HistoryQuery) useTier(tier Tier) {
+	if tier.Rollup() && q.Window%tier.Resolution != 0 {
+		q.Window = (q.Window/tier.Resolution + 1) * tier.Resolution
+	}
+}
@@ -0,0 +1,217 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Buffered events per subscriber; slow browsers drop samples instead of
+	// blocking the other viewers
+	subscriberBuffer = 8
+
+	reconnectDelay = 3 * time.Second
+)
+
+// Event is one Server-Sent Event relayed from an agent
+type Event struct {
+	Name string
+	Data string
+}
+
+type streamKey struct {
+	agentID  string
+	interval time.Duration
+}
+
+// Endpoint returns the current base URL of an agent and its token, if any.
+// It is called before every connection attempt, so reconnects follow an
+// address or token change.
+type Endpoint func() (baseURL, token string, err error)
+
+// upstream is a single connection to an agent's stream shared by all
+// subscribers asking for the same agent and interval
+type upstream struct {
+	endpoint    Endpoint
+	interval    time.Duration
+	subscribers map[chan Event]struct{}
+	last        *Event
+	cancel      context.CancelFunc
+}
+
+// Hub fans out agent metric streams to any number of browser clients while
+// keeping at most one upstream connection per agent and interval
+type Hub struct {
+	client  *http.Client
+	streams map[streamKey]*upstream
+	mu      sync.Mutex
+}
+
+// NewHub connects to agents through transport (nil for the default)
+func NewHub(transport http.RoundTripper) *Hub {
+	return &Hub{
+		// No client timeout: streams are long-lived and cancelled via context
+		client:  &http.Client{Transport: transport},
+		streams: make(map[streamKey]*upstream),
+	}
+}
+
+// Subscribe returns a channel of events from the stream of the agent at
+// endpoint, and a function to call when the subscriber goes away
+func (h *Hub) Subscribe(agentID string, endpoint Endpoint, interval time.Duration) (<-chan Event, func()) {
+	key := streamKey{agentID: agentID, interval: interval}
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	up, exists := h.streams[key]
+	if !exists {
+		ctx, cancel := context.WithCancel(context.Background())
+		up = &upstream{
+			endpoint:    endpoint,
+			interval:    interval,
+			subscribers: make(map[chan Event]struct{}),
+			cancel:      cancel,
+		}
+		h.streams[key] = up
+		go h.run(ctx, key, up)
+		log.Printf("Opened metrics stream for %s (interval: %v)", agentID, interval)
+	}
+	up.subscribers[ch] = struct{}{}
+	// Late joiners get the latest sample right away
+	if up.last != nil {
+		ch <- *up.last
+	}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := up.subscribers[ch]; !ok {
+			return
+		}
+		delete(up.subscribers, ch)
+		close(ch)
+
+		if len(up.subscribers) == 0 {
+			up.cancel()
+			delete(h.streams, key)
+			log.Printf("Closed metrics stream for %s (interval: %v)", agentID, interval)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+// Subscribers returns the number of browser clients per agent
+func (h *Hub) Subscribers() map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := make(map[string]int)
+	for key, up := range h.streams {
+		counts[key.agentID] += len(up.subscribers)
+	}
+	return counts
+}
+
+// run keeps the upstream connection open until ctx is cancelled
+func (h *Hub) run(ctx context.Context, key streamKey, up *upstream) {
+	for {
+		err := h.consume(ctx, up)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Metrics stream for %s interrupted: %v", key.agentID, err)
+		h.broadcast(up, Event{Name: "error", Data: fmt.Sprintf("%q", "agent stream unavailable")})
+
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// consume reads Server-Sent Events from the agent and relays them
+func (h *Hub) consume(ctx context.Context, up *upstream) error {
+	baseURL, token, err := up.endpoint()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/metrics/stream?interval=%s", baseURL, up.interval)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var event Event
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// Blank line terminates an event
+			if event.Data != "" {
+				if event.Name == "" {
+					event.Name = "message"
+				}
+				h.broadcast(up, event)
+			}
+			event = Event{}
+		case strings.HasPrefix(line, "event:"):
+			event.Name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			if event.Data != "" {
+				event.Data += "\n"
+			}
+			event.Data += data
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("agent closed the stream")
+}
+
+func (h *Hub) broadcast(up *upstream, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if event.Name == "metrics" {
+		up.last = &event
+	}
+	for ch := range up.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Subscriber is lagging behind; drop the sample
+		}
+	}
+}
@@ -4,20 +4,136 @@ export interface Agent {
   id: string;
   hostname: string;
   ip_address: string;
+  addresses?: string[];
+  address_family?: 'auto' | 'ipv4' | 'ipv6';
   port: number;
   added_at: string;
-  last_seen: string;
-  status: 'online' | 'offline' | 'unknown';
+  // Missing until the first successful poll
+  last_seen?: string;
+  status: AgentStatus;
+  failures: number;
+  next_poll?: string;
+  clock_skew_ms?: number;
+  schema_version?: number;
+  schema_warning?: string;
+  agent_health?: AgentHealth;
+  scheme?: 'http' | 'https';
+  // The token itself is never returned
+  auth_token_set?: boolean;
+  display_name?: string;
+  tags?: string[];
+  groups?: string[];
+  location?: string;
+  notes?: string;
+  thresholds?: Thresholds;
+  polling?: Polling;
+}
+
+export type AgentStatus = 'unknown' | 'online' | 'degraded' | 'stale' | 'offline' | 'paused';
+
+// Zero or missing fields inherit from the agent's groups, then the dashboard defaults
+export interface Thresholds {
+  slow_response_ms?: number;
+  stale_after?: number;
+  offline_after?: number;
+}
+
+// Inherited like Thresholds
+export interface Polling {
+  interval_ms?: number;
+  timeout_ms?: number;
+  max_backoff_ms?: number;
+}
+
+export interface Group {
+  name: string;
+  thresholds?: Thresholds;
+  polling?: Polling;
+}
+
+export interface StatusEvent {
+  time: string;
+  from: string;
+  to: string;
+  reason:
+    | 'registered'
+    | 'responded'
+    | 'timeout'
+    | 'connection_refused'
+    | 'unreachable'
+    | 'http_error'
+    | 'bad_payload'
+    | 'slow'
+    | 'collector_errors'
+    | 'paused'
+    | 'resumed';
+}
+
+export interface Availability {
+  from: string;
+  to: string;
+  up_seconds: number;
+  down_seconds: number;
+  percent: number | null;
+}
+
+export interface StatusEventsPage {
+  agent_id: string;
+  from: string;
+  to: string;
+  events: StatusEvent[];
+  next: string | null;
+  availability: Availability;
+}
+
+export type AgentMetadata = Pick<Agent, 'display_name' | 'tags' | 'groups' | 'location' | 'notes' | 'thresholds' | 'polling'> & {
+  // Connection settings only change when given, even with PUT
+  tls?: boolean;
+  auth_token?: string;
+};
+
+export interface AgentFilter {
+  tag?: string[];
+  group?: string[];
+  status?: Agent['status'][];
+}
+
+export interface AgentHealth {
+  status: 'ok' | 'degraded';
+  version: string;
+  uptime_seconds: number;
+  goroutines: number;
+  rss_bytes: number;
+  collector_errors: number;
+  failing_collectors?: string[];
+  checked_at: string;
 }
 
 export interface DiscoveredAgent {
+  id?: string;
   hostname: string;
   instance: string;
   port: number;
   ips: string[];
+  version?: string;
+  os?: string;
+  arch?: string;
+  tls: boolean;
+  auth_required: boolean;
+  tags?: string[];
+}
+
+export interface SeenAgent extends DiscoveredAgent {
+  first_seen: string;
+  last_seen: string;
+  state: 'pending' | 'registered' | 'adopted';
+  agent_id?: string;
+  adopted_by?: string;
+  adopt_error?: string;
 }
 
 export interface SystemMetrics {
+  agent_id?: string;
   timestamp: string;
   hostname: string;
   uptime: number;
@@ -51,6 +167,69 @@ export interface SystemMetrics {
   }>;
 }
 
+// rate turns counters (network fields) into per-second rates
+export type HistoryAggregate = 'mean' | 'min' | 'max' | 'last' | 'p95' | 'p99' | 'rate';
+
+export interface HistoryQuery {
+  duration?: string;
+  from?: string;
+  to?: string;
+  window?: string;
+  points?: number;
+  aggregate?: HistoryAggregate;
+  field?: string[];
+  // Tag filters such as mount_point or interface
+  tags?: Record<string, string[]>;
+}
+
+// times[i] (Unix milliseconds) and values[i] form one point
+export interface HistorySeries {
+  field: string;
+  tags?: Record<string, string>;
+  times: number[];
+  values: number[];
+}
+
+export interface HistoryResult {
+  agent_id: string;
+  measurement: string;
+  start: string;
+  end: string;
+  window_ns: number;
+  aggregate: HistoryAggregate;
+  // Storage tier the points come from: raw, or rollups like 5m and 1h
+  tier: string;
+  series: HistorySeries[];
+}
+
+export type FleetFunction = 'min' | 'max' | 'mean' | 'sum';
+
+// Values line up with times; null where a series has no point
+export interface AlignedSeries {
+  field: string;
+  tags?: Record<string, string>;
+  function?: FleetFunction;
+  values: (number | null)[];
+}
+
+export interface FleetHistory {
+  measurement: string;
+  start: string;
+  end: string;
+  window_ns: number;
+  aggregate: HistoryAggregate;
+  tier: string;
+  times: number[];
+  agents: { agent_id: string; name: string; series: AlignedSeries[] }[];
+  fleet: AlignedSeries[];
+}
+
+export interface FleetSelection extends AgentFilter {
+  // Agent IDs, or ['all']
+  agent?: string[];
+  fleet?: FleetFunction[];
+}
+
 async function fetchWithTimeout(url: string, options: RequestInit = {}, timeout = 5000): Promise<Response> {
   const controller = new AbortController();
   const timeoutId = setTimeout(() => controller.abort(), timeout);
@@ -68,13 +247,53 @@ async function fetchWithTimeout(url: string, options: RequestInit = {}, timeout
   }
 }
 
+function historyParams(query: HistoryQuery): URLSearchParams {
+  const params = new URLSearchParams({ format: 'columns' });
+  for (const key of ['duration', 'from', 'to', 'window', 'aggregate'] as const) {
+    if (query[key]) params.set(key, query[key]!);
+  }
+  if (query.points) params.set('points', String(query.points));
+  if (query.field?.length) params.set('field', query.field.join(','));
+  for (const [tag, values] of Object.entries(query.tags ?? {})) {
+    params.set(tag, values.join(','));
+  }
+  return params;
+}
+
 export const api = {
-  async getAgents(): Promise<Agent[]> {
-    const response = await fetchWithTimeout(`${API_BASE}/agents`);
+  async getAgents(filter: AgentFilter = {}): Promise<Agent[]> {
+    const params = new URLSearchParams();
+    for (const [key, values] of Object.entries(filter)) {
+      if (values?.length) params.set(key, values.join(','));
+    }
+    const query = params.toString();
+    const response = await fetchWithTimeout(`${API_BASE}/agents${query ? `?${query}` : ''}`);
     return response.json();
   },
 
-  async addAgent(agent: { ip_address: string; port: number; hostname: string }): Promise<void> {
+  // Only the given fields change; use replace to clear the others
+  async updateAgent(id: string, metadata: AgentMetadata, replace = false): Promise<Agent> {
+    const response = await fetchWithTimeout(`${API_BASE}/agents/${id}`, {
+      method: replace ? 'PUT' : 'PATCH',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(metadata),
+    });
+    if (!response.ok) {
+      const { error } = await response.json();
+      throw new Error(error);
+    }
+    return response.json();
+  },
+
+  async addAgent(agent: {
+    ip_address?: string;
+    ip_addresses?: string[];
+    address_family?: 'auto' | 'ipv4' | 'ipv6';
+    port: number;
+    hostname: string;
+    tls?: boolean;
+    auth_token?: string;
+  }): Promise<void> {
     await fetchWithTimeout(`${API_BASE}/agents`, {
       method: 'POST',
       headers: { 'Content-Type': 'application/json' },
@@ -82,6 +301,29 @@ export const api = {
     });
   },
 
+  // Pass the previous page's next as cursor, with the same range, to continue
+  async getAgentEvents(id: string, range: { from?: string; to?: string; limit?: number; cursor?: string } = {}): Promise<StatusEventsPage> {
+    const params = new URLSearchParams();
+    if (range.from) params.set('from', range.from);
+    if (range.to) params.set('to', range.to);
+    if (range.cursor) params.set('cursor', range.cursor);
+    if (range.limit) params.set('limit', String(range.limit));
+    const response = await fetchWithTimeout(`${API_BASE}/agents/${id}/events?${params}`);
+    return response.json();
+  },
+
+  async getAvailability(id: string, windows = ['24h', '7d', '30d']): Promise<Record<string, Availability>> {
+    const response = await fetchWithTimeout(`${API_BASE}/agents/${id}/availability?windows=${windows.join(',')}`);
+    return response.json();
+  },
+
+  async setPaused(id: string, paused: boolean): Promise<Agent> {
+    const response = await fetchWithTimeout(`${API_BASE}/agents/${id}/${paused ? 'pause' : 'resume'}`, {
+      method: 'POST',
+    });
+    return response.json();
+  },
+
   async removeAgent(id: string): Promise<void> {
     await fetchWithTimeout(`${API_BASE}/agents/${id}`, {
       method: 'DELETE',
@@ -93,11 +335,59 @@ export const api = {
     return response.json();
   },
 
+  async getDiscoveryTable(state?: SeenAgent['state']): Promise<{ last_scan: string | null; agents: SeenAgent[] }> {
+    const query = state ? `?state=${state}` : '';
+    const response = await fetchWithTimeout(`${API_BASE}/discovery${query}`);
+    return response.json();
+  },
+
   async getMetrics(agentId: string): Promise<SystemMetrics> {
     const response = await fetchWithTimeout(`${API_BASE}/metrics/${agentId}`, {}, 3000);
     return response.json();
   },
 
+  async getHistory(agentId: string, measurement: string, query: HistoryQuery = {}): Promise<HistoryResult> {
+    const response = await fetchWithTimeout(
+      `${API_BASE}/history/${encodeURIComponent(agentId)}/${measurement}?${historyParams(query)}`,
+      {},
+      10000,
+    );
+    if (!response.ok) {
+      const { error } = await response.json();
+      throw new Error(error);
+    }
+    return response.json();
+  },
+
+  async getFleetHistory(measurement: string, selection: FleetSelection, query: HistoryQuery = {}): Promise<FleetHistory> {
+    const params = historyParams(query);
+    params.set('measurement', measurement);
+    for (const [key, values] of Object.entries(selection)) {
+      if (values?.length) params.set(key, values.join(','));
+    }
+    const response = await fetchWithTimeout(`${API_BASE}/history?${params}`, {}, 10000);
+    if (!response.ok) {
+      const { error } = await response.json();
+      throw new Error(error);
+    }
+    return response.json();
+  },
+
+  // Subscribe to live metrics; returns a function that closes the stream
+  streamMetrics(
+    agentId: string,
+    onMetrics: (metrics: SystemMetrics) => void,
+    onError?: () => void,
+    interval = '5s',
+  ): () => void {
+    const source = new EventSource(`${API_BASE}/stream/${agentId}?interval=${interval}`);
+    source.addEventListener('metrics', (event) => {
+      onMetrics(JSON.parse((event as MessageEvent).data));
+    });
+    source.addEventListener('error', () => onError?.());
+    return () => source.close();
+  },
+
   async checkHealth(): Promise<{ status: string }> {
     const response = await fetchWithTimeout(`${API_BASE}/health`);
     return response.json();
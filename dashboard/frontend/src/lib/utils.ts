@@ -37,4 +37,8 @@ export function formatUptime(seconds: number): string {
 
 export function formatPercent(value: number): string {
   return `${value.toFixed(1)}%`;
-}
\ No newline at end of file
+}
+// Brackets IPv6 literals: "::1", 9100 -> "[::1]:9100"
+export function formatHostPort(host: string, port: number): string {
+  return host.includes(':') ? `[${host}]:${port}` : `${host}:${port}`;
+}
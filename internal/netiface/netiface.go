@@ -0,0 +1,70 @@
+// Package netiface selects the network interfaces used for mDNS.
+package netiface
+
+import (
+	"fmt"
+	"net"
+	"path"
+)
+
+// Select returns the up, multicast-capable interfaces whose names match one
+// of the allow patterns (all when empty) and none of the deny patterns.
+// Patterns are globs such as "eth*". It returns nil when both lists are
+// empty, letting the mDNS library pick its defaults.
+func Select(allow, deny []string) ([]net.Interface, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	var selected []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if len(allow) > 0 && !matchAny(allow, iface.Name) {
+			continue
+		}
+		if matchAny(deny, iface.Name) {
+			continue
+		}
+		selected = append(selected, iface)
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no multicast interface matches interfaces=%v exclude=%v", allow, deny)
+	}
+	return selected, nil
+}
+
+// Names returns the interface names, for logging
+func Names(ifaces []net.Interface) []string {
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = iface.Name
+	}
+	return names
+}
+
+// ValidatePatterns checks that every pattern is a valid glob
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+// Package schema defines the metrics payload agents serve on /metrics and
+// the dashboard consumes. Both binaries build against it, so the two sides
+// can't drift apart silently.
+package schema
+
+import (
+	"fmt"
+	"time"
+)
+
+// Version of the payload produced by this build. Bump it on any change the
+// other side needs to know about, and keep the dashboard able to read every
+// version down to MinVersion.
+//
+//	1: original payload, without schema_version. agent_id was added later
+//	   without a bump, so version 1 payloads may lack it.
+//	2: adds schema_version; agent_id is always set
+const Version = 2
+
+// MinVersion is the oldest payload the dashboard still understands
+const MinVersion = 1
+
+// legacyVersion is assumed for payloads that don't carry a version
+const legacyVersion = 1
+
+// SystemMetrics holds all collected system information
+type SystemMetrics struct {
+	SchemaVersion int              `json:"schema_version"`
+	AgentID       string           `json:"agent_id"` // Empty from some version 1 agents
+	Timestamp     time.Time        `json:"timestamp"`
+	Hostname      string           `json:"hostname"`
+	Uptime        uint64           `json:"uptime"`
+	CPU           CPUMetrics       `json:"cpu"`
+	Memory        MemoryMetrics    `json:"memory"`
+	Disk          []DiskMetrics    `json:"disk"`
+	Network       []NetworkMetrics `json:"network"`
+}
+
+type CPUMetrics struct {
+	UsagePercent float64   `json:"usage_percent"`
+	CoreCount    int       `json:"core_count"`
+	LoadAvg      []float64 `json:"load_avg,omitempty"` // Linux/Unix only
+	Model        string    `json:"model"`
+}
+
+type MemoryMetrics struct {
+	Total       uint64  `json:"total"`
+	Available   uint64  `json:"available"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type DiskMetrics struct {
+	Device      string  `json:"device"`
+	MountPoint  string  `json:"mount_point"`
+	FSType      string  `json:"fs_type"`
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Free        uint64  `json:"free"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+type NetworkMetrics struct {
+	Interface   string `json:"interface"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+}
+
+// ErrTooOld and ErrTooNew describe payloads outside the supported range
+var (
+	ErrTooOld = fmt.Errorf("schema too old")
+	ErrTooNew = fmt.Errorf("schema too new")
+)
+
+// Upgrade brings a decoded payload up to the current version and reports
+// whether it can be used. Payloads from agents older than MinVersion are
+// rejected with ErrTooOld. Payloads newer than Version are kept, since new
+// fields are only ever added, but come back with ErrTooNew so the caller
+// can warn about it.
+func Upgrade(m *SystemMetrics) error {
+	if m.SchemaVersion == 0 {
+		m.SchemaVersion = legacyVersion
+	}
+
+	switch {
+	case m.SchemaVersion < MinVersion:
+		return fmt.Errorf("%w: version %d, need at least %d", ErrTooOld, m.SchemaVersion, MinVersion)
+	case m.SchemaVersion > Version:
+		return fmt.Errorf("%w: version %d, dashboard understands up to %d", ErrTooNew, m.SchemaVersion, Version)
+	}
+
+	// Version 2 only added schema_version, set above. agent_id stays empty
+	// in payloads from agents that predate it, so callers identify agents
+	// by their registration rather than by the payload.
+	return nil
+}